@@ -0,0 +1,65 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apis
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/gin-gonic/gin"
+)
+
+type adminResource struct {
+	reload func() error
+}
+
+// ServeAdminResource sets up the administrative routes. These are not meant
+// to be public and should only be served on a local listener.
+func ServeAdminResource(rg *gin.RouterGroup, reload func() error) {
+	r := &adminResource{reload}
+	rg.POST("/reload", r.postReload)
+}
+
+// RequireToken rejects requests not presenting token as bearer token. An
+// empty token rejects everything.
+func RequireToken(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		actual := []byte(c.GetHeader("Authorization"))
+		if len(token) == 0 || subtle.ConstantTimeCompare(expected, actual) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, models.Error{Error: "unauthorized"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Re-opens the database from disk. Answers with OK or why the current
+// database was kept.
+func (r *adminResource) postReload(c *gin.Context) {
+	if err := r.reload(); err != nil {
+		c.String(http.StatusInternalServerError, "%s\n", err)
+		return
+	}
+	c.String(http.StatusOK, "OK\n")
+}
@@ -0,0 +1,88 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apis
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminReload(t *testing.T) {
+	var reloadErr error
+	reloads := 0
+	router := gin.New()
+	ServeAdminResource(router.Group("/"), func() error {
+		reloads++
+		return reloadErr
+	})
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("POST", "/reload", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "OK\n", res.Body.String())
+
+	reloadErr = errors.New("canaries failed")
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("POST", "/reload", nil))
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+	assert.Equal(t, "canaries failed\n", res.Body.String())
+
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/reload", nil))
+	assert.Equal(t, http.StatusNotFound, res.Code)
+	assert.Equal(t, 2, reloads)
+}
+
+func TestRequireToken(t *testing.T) {
+	reloads := 0
+	router := gin.New()
+	ServeAdminResource(router.Group("/", RequireToken("secret")), func() error {
+		reloads++
+		return nil
+	})
+	request := func(header http.Header) int {
+		req := httptest.NewRequest("POST", "/reload", nil)
+		req.Header = header
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request(http.Header{}))
+	assert.Equal(t, http.StatusUnauthorized, request(http.Header{"Authorization": {"Bearer wrong"}}))
+	assert.Equal(t, http.StatusUnauthorized, request(http.Header{"Authorization": {"secret"}}))
+	assert.Equal(t, 0, reloads)
+	assert.Equal(t, http.StatusOK, request(http.Header{"Authorization": {"Bearer secret"}}))
+	assert.Equal(t, 1, reloads)
+
+	router = gin.New()
+	router.GET("/", RequireToken(""), func(c *gin.Context) { c.Status(http.StatusOK) })
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
@@ -26,6 +26,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// Seconds clients should wait before retrying while the database is
+// unavailable. A first download usually finishes within a minute.
+const retryAfter = "60"
+
 func clientIP(c *gin.Context) net.IP {
 	if ip := c.Query("ip"); len(ip) > 0 {
 		return net.ParseIP(ip)
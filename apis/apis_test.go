@@ -22,10 +22,14 @@ package apis
 
 import (
 	"bytes"
+	"errors"
 	"net"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/apachelogger/geoip-kde-org/locator"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -58,16 +62,36 @@ func equalJSON(t *testing.T, test apiTestCase, res *httptest.ResponseRecorder) {
 
 func testAPI(method, URL, body string) *httptest.ResponseRecorder {
 	req := httptest.NewRequest(method, URL, bytes.NewBufferString(body))
-	// NB: we work against the live data as we have insufficient data/api
-	// separation, so this may eventually fail to meet expectation.
-	// Unfortunately the live data doesn't handle 192.0.2.0/24, which would be
-	// an RFC defined address solely for testing.
-	req.RemoteAddr = "91.189.93.5:1234"
+	// Resources are tested against the synthetic dbtest database.
+	req.RemoteAddr = dbtest.Vienna + ":1234"
 	res := httptest.NewRecorder()
 	router.ServeHTTP(res, req)
 	return res
 }
 
+// Requests URL from a fresh router where serve set up its resource with l.
+func testLocatorAPI(serve func(*gin.RouterGroup, locator.Locator), l locator.Locator, method, URL string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	serve(router.Group("/"), l)
+	req := httptest.NewRequest(method, URL, nil)
+	req.RemoteAddr = dbtest.Vienna + ":1234"
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	return res
+}
+
+// Requests URL from a fresh router where serve set up its resource without
+// any database loaded.
+func testUnavailableAPI(serve func(*gin.RouterGroup, locator.Locator), method, URL string) *httptest.ResponseRecorder {
+	return testLocatorAPI(serve, locator.New(database.NewSet()), method, URL)
+}
+
+// A locator failing every lookup.
+var failingLocator = locator.Func(func(ip net.IP) (locator.Location, error) {
+	return locator.Location{}, errors.New("lookup failed")
+})
+
 func runAPITests(t *testing.T, tests []apiTestCase) {
 	for _, test := range tests {
 		t.Run(test.tag, func(t *testing.T) {
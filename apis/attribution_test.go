@@ -0,0 +1,46 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttribution(t *testing.T) {
+	notice := ""
+	router := gin.New()
+	router.Use(Attribution(func() string { return notice }))
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, res.Header().Get(AttributionHeader))
+
+	notice = "Data by someone"
+	res = httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, "Data by someone", res.Header().Get(AttributionHeader))
+}
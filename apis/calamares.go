@@ -23,19 +23,19 @@ package apis
 import (
 	"net/http"
 
+	"github.com/apachelogger/geoip-kde-org/locator"
 	"github.com/apachelogger/geoip-kde-org/models"
 	"github.com/gin-gonic/gin"
-	geoip2 "github.com/oschwald/geoip2-golang"
 )
 
-// We are muddying the waters a bit by merging api+service+data.
+// Lookups are left to the locator, the resource only speaks HTTP.
 type calamaresResource struct {
-	db *geoip2.Reader
+	locator locator.Locator
 }
 
 // ServeCalamaresResource sets up the calamares resource routes.
-func ServeCalamaresResource(rg *gin.RouterGroup, db *geoip2.Reader) {
-	r := &calamaresResource{db}
+func ServeCalamaresResource(rg *gin.RouterGroup, l locator.Locator) {
+	r := &calamaresResource{l}
 	rg.GET("/v1/calamares", r.get)
 }
 
@@ -51,14 +51,25 @@ func ServeCalamaresResource(rg *gin.RouterGroup, db *geoip2.Reader) {
  *
  * @apiSuccessExample {json} Success-Response:
  *   {"time_zone":"Europe/Vienna"}
+ *
+ * @apiError (503) DatabaseUnavailable No database has been loaded yet (e.g.
+ *   right after the first start). Retry-After says when to try again.
+ *
+ * @apiErrorExample {json} Error-Response:
+ *   HTTP/1.1 503 Service Unavailable
+ *   {"error":"database unavailable"}
  */
 func (r *calamaresResource) get(c *gin.Context) {
-	// If you are using strings that may be invalid, check that ip is not nil
-	record, err := r.db.City(clientIP(c))
+	location, err := r.locator.Locate(clientIP(c))
+	if err == locator.ErrUnavailable {
+		c.Header("Retry-After", retryAfter)
+		c.JSON(http.StatusServiceUnavailable, models.Error{Error: err.Error()})
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
 
-	data := models.CalamaresGeoIP{TimeZone: record.Location.TimeZone}
+	data := models.CalamaresGeoIP{TimeZone: location.TimeZone}
 	c.JSON(http.StatusOK, data)
 }
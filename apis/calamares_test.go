@@ -24,20 +24,35 @@ import (
 	"net/http"
 	"testing"
 
-	geoip2 "github.com/oschwald/geoip2-golang"
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/apachelogger/geoip-kde-org/locator"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCalamaresResource(t *testing.T) {
-	db, err := geoip2.Open("../GeoLite2-City.mmdb")
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
+	dbs := database.NewSet()
+	dbtest.Load(t, dbs.City)
+	ServeCalamaresResource(router.Group("/"), locator.New(dbs))
 
-	ServeCalamaresResource(router.Group("/"), db)
-
-	kdeDotOrg := `{"time_zone":"Europe/London"}`
 	runAPITests(t, []apiTestCase{
-		{"t1 - get", "GET", "/v1/calamares", "", http.StatusOK, kdeDotOrg, equalJSON},
+		{"t1 - get", "GET", "/v1/calamares", "", http.StatusOK, `{"time_zone":"Europe/Vienna"}`, equalJSON},
+		{"t2 - no city", "GET", "/v1/calamares?ip=" + dbtest.NoCity, "", http.StatusOK, `{"time_zone":"Europe/London"}`, equalJSON},
+		{"t3 - no time zone", "GET", "/v1/calamares?ip=" + dbtest.NoTimeZone, "", http.StatusOK, `{"time_zone":""}`, equalJSON},
+		{"t4 - ipv6", "GET", "/v1/calamares?ip=" + dbtest.NoSubdivisions, "", http.StatusOK, `{"time_zone":"Atlantic/Reykjavik"}`, equalJSON},
+		{"t5 - private", "GET", "/v1/calamares?ip=" + dbtest.Private, "", http.StatusOK, `{"time_zone":"Asia/Kabul"}`, equalJSON},
+		{"t6 - unknown", "GET", "/v1/calamares?ip=" + dbtest.Unknown, "", http.StatusOK, `{"time_zone":""}`, equalJSON},
 	})
 }
+
+func TestCalamaresResourceUnavailable(t *testing.T) {
+	res := testUnavailableAPI(ServeCalamaresResource, "GET", "/v1/calamares")
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+	equalJSON(t, apiTestCase{response: `{"error":"database unavailable"}`}, res)
+}
+
+func TestCalamaresResourceFailure(t *testing.T) {
+	res := testLocatorAPI(ServeCalamaresResource, failingLocator, "GET", "/v1/calamares")
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+}
@@ -21,31 +21,35 @@
 package apis
 
 import (
-	"fmt"
 	"net/http"
 
+	"github.com/apachelogger/geoip-kde-org/locator"
+	"github.com/apachelogger/geoip-kde-org/models"
 	"github.com/gin-gonic/gin"
-	geoip2 "github.com/oschwald/geoip2-golang"
 )
 
 type debugResource struct {
-	db *geoip2.Reader
+	locator locator.Locator
 }
 
 // ServeDebugResource sets up the semi-internal data inspection resource.
 // Its format is entirely undefined and absolutely not meant to for consumption.
-func ServeDebugResource(rg *gin.RouterGroup, db *geoip2.Reader) {
-	r := &debugResource{db}
+func ServeDebugResource(rg *gin.RouterGroup, l locator.Locator) {
+	r := &debugResource{l}
 	rg.GET("/debug", r.get)
 }
 
 func (r *debugResource) get(c *gin.Context) {
-	// If you are using strings that may be invalid, check that ip is not nil
-	record, err := r.db.City(clientIP(c))
+	ip := clientIP(c)
+	location, err := r.locator.Locate(ip)
+	if err == locator.ErrUnavailable {
+		c.Header("Retry-After", retryAfter)
+		c.JSON(http.StatusServiceUnavailable, models.Error{Error: err.Error()})
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
-	fmt.Printf("%+v\n", record)
 
-	c.JSON(http.StatusOK, record)
+	c.JSON(http.StatusOK, gin.H{"location": location})
 }
@@ -0,0 +1,68 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apis
+
+import (
+	"net/http"
+
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/gin-gonic/gin"
+)
+
+type metaResource struct {
+	meta func() models.Meta
+}
+
+// ServeMetaResource sets up the route describing what is served, as told
+// by meta.
+func ServeMetaResource(rg *gin.RouterGroup, meta func() models.Meta) {
+	r := &metaResource{meta}
+	rg.GET("/v1/meta", r.get)
+}
+
+/**
+ * @api {get} /meta Meta
+ *
+ * @apiVersion 1.0.0
+ * @apiGroup Service
+ * @apiName meta
+ *
+ * @apiDescription Which database builds are served. Editions that are not
+ *   loaded (yet) are not listed. next_update is missing while an update is
+ *   running.
+ *
+ * @apiSuccessExample {json} Success-Response:
+ *   {
+ *     "version": "1.0.0",
+ *     "provider": "maxmind",
+ *     "editions": [
+ *       {
+ *         "edition": "GeoLite2-City",
+ *         "build_epoch": 1523952000,
+ *         "downloaded": "2018-04-17T12:00:00Z"
+ *       }
+ *     ],
+ *     "next_update": "2018-04-18T12:23:00Z"
+ *   }
+ */
+func (r *metaResource) get(c *gin.Context) {
+	c.JSON(http.StatusOK, r.meta())
+}
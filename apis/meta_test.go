@@ -0,0 +1,59 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apis
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaResource(t *testing.T) {
+	next := time.Date(2018, time.April, 18, 12, 23, 0, 0, time.UTC)
+	router := gin.New()
+	ServeMetaResource(router.Group("/"), func() models.Meta {
+		return models.Meta{
+			Version:  "1.0.0",
+			Provider: "maxmind",
+			Editions: []models.EditionMeta{{
+				Edition:    "GeoLite2-City",
+				BuildEpoch: 1523952000,
+				Downloaded: time.Date(2018, time.April, 17, 12, 0, 0, 0, time.UTC),
+			}},
+			NextUpdate: &next,
+		}
+	})
+
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, httptest.NewRequest("GET", "/v1/meta", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{
+		"version": "1.0.0",
+		"provider": "maxmind",
+		"editions": [{"edition": "GeoLite2-City", "build_epoch": 1523952000, "downloaded": "2018-04-17T12:00:00Z"}],
+		"next_update": "2018-04-18T12:23:00Z"
+	}`, res.Body.String())
+}
@@ -0,0 +1,70 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apis
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/gin-gonic/gin"
+)
+
+type replicaResource struct {
+	replica func(edition string) (models.Replica, error)
+}
+
+// ServeReplicaResource sets up the route followers fetch the served
+// databases from, replica tells which file is served for an edition. rg
+// needs to be guarded (see RequireToken), the route itself doesn't check
+// who is asking.
+func ServeReplicaResource(rg *gin.RouterGroup, replica func(edition string) (models.Replica, error)) {
+	r := &replicaResource{replica}
+	rg.GET("/replica/:edition", r.get)
+}
+
+// Streams the database of an edition. Supports If-None-Match and ranges.
+func (r *replicaResource) get(c *gin.Context) {
+	replica, err := r.replica(c.Param("edition"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.Error{Error: err.Error()})
+		return
+	}
+	file, err := os.Open(replica.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Error{Error: err.Error()})
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.Error{Error: err.Error()})
+		return
+	}
+
+	c.Header("ETag", `"`+replica.SHA256+`"`)
+	c.Header(models.ReplicaVersionHeader, strconv.FormatUint(uint64(replica.Version), 10))
+	c.Header(models.ReplicaChecksumHeader, replica.SHA256)
+	c.Header("Content-Type", "application/octet-stream")
+	http.ServeContent(c.Writer, c.Request, filepath.Base(replica.Path), info.ModTime(), file)
+}
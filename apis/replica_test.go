@@ -0,0 +1,92 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package apis
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplica(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip-replica-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "GeoLite2-City_1523952000.mmdb")
+	ioutil.WriteFile(path, []byte("mmdb"), 0644)
+
+	router := gin.New()
+	ServeReplicaResource(router.Group("/", RequireToken("secret")), func(edition string) (models.Replica, error) {
+		if edition != "GeoLite2-City" {
+			return models.Replica{}, fmt.Errorf("%s is not served", edition)
+		}
+		return models.Replica{Path: path, Version: 1523952000, SHA256: "abc"}, nil
+	})
+	request := func(edition string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/replica/"+edition, nil)
+		req.Header = header
+		res := httptest.NewRecorder()
+		router.ServeHTTP(res, req)
+		return res
+	}
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+
+	res := request("GeoLite2-City", auth)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Equal(t, "mmdb", res.Body.String())
+	assert.Equal(t, "1523952000", res.Header().Get(models.ReplicaVersionHeader))
+	assert.Equal(t, "abc", res.Header().Get(models.ReplicaChecksumHeader))
+	assert.Equal(t, `"abc"`, res.Header().Get("ETag"))
+
+	res = request("GeoLite2-City", http.Header{"Authorization": {"Bearer secret"}, "If-None-Match": {`"abc"`}})
+	assert.Equal(t, http.StatusNotModified, res.Code)
+
+	res = request("GeoLite2-ASN", auth)
+	assert.Equal(t, http.StatusNotFound, res.Code)
+
+	res = request("GeoLite2-City", http.Header{"Authorization": {"Bearer wrong"}})
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+	res = request("GeoLite2-City", http.Header{})
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
+
+func TestReplicaWithoutToken(t *testing.T) {
+	router := gin.New()
+	ServeReplicaResource(router.Group("/", RequireToken("")), func(edition string) (models.Replica, error) {
+		t.Fatal("replica looked up without authorization")
+		return models.Replica{}, nil
+	})
+	req := httptest.NewRequest("GET", "/replica/GeoLite2-City", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	res := httptest.NewRecorder()
+	router.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+}
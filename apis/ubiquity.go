@@ -23,19 +23,19 @@ package apis
 import (
 	"net/http"
 
+	"github.com/apachelogger/geoip-kde-org/locator"
 	"github.com/apachelogger/geoip-kde-org/models"
 	"github.com/gin-gonic/gin"
-	geoip2 "github.com/oschwald/geoip2-golang"
 )
 
-// We are muddying the waters a bit by merging api+service+data.
+// Lookups are left to the locator, the resource only speaks HTTP.
 type ubiquityResource struct {
-	db *geoip2.Reader
+	locator locator.Locator
 }
 
 // ServeUbiquityResource sets up the ubiquity resource routes.
-func ServeUbiquityResource(rg *gin.RouterGroup, db *geoip2.Reader) {
-	r := &ubiquityResource{db}
+func ServeUbiquityResource(rg *gin.RouterGroup, l locator.Locator) {
+	r := &ubiquityResource{l}
 	rg.GET("/v1/ubiquity", r.get)
 }
 
@@ -66,15 +66,30 @@ func ServeUbiquityResource(rg *gin.RouterGroup, db *geoip2.Reader) {
  *   <AreaCode>0</AreaCode>
  *   <TimeZone>Europe/Vienna</TimeZone>
  *   </Response>
+ *
+ * @apiError (503) DatabaseUnavailable No database has been loaded yet (e.g.
+ *   right after the first start). Retry-After says when to try again.
+ *
+ * @apiErrorExample {xml} Error-Response:
+ *   HTTP/1.1 503 Service Unavailable
+ *   <Response>
+ *   <Ip>193.81.57.56</Ip>
+ *   <Status>ERROR</Status>
+ *   ...all other elements empty...
+ *   </Response>
  */
 func (r *ubiquityResource) get(c *gin.Context) {
-	// If you are using strings that may be invalid, check that ip is not nil
 	ip := clientIP(c)
-	record, err := r.db.City(ip)
+	location, err := r.locator.Locate(ip)
+	if err == locator.ErrUnavailable {
+		c.Header("Retry-After", retryAfter)
+		c.XML(http.StatusServiceUnavailable, models.NewUbiquityGeoIPError(ip.String()))
+		return
+	}
 	if err != nil {
 		panic(err)
 	}
 
-	data := models.NewUbiquityGeoIPFromGeoIP2Record(ip.String(), record)
+	data := models.NewUbiquityGeoIPFromLocation(ip.String(), location)
 	c.XML(http.StatusOK, data)
 }
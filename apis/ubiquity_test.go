@@ -27,8 +27,10 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/apachelogger/geoip-kde-org/locator"
 	"github.com/apachelogger/geoip-kde-org/models"
-	geoip2 "github.com/oschwald/geoip2-golang"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -45,32 +47,92 @@ func equalUbiquity(t *testing.T, test apiTestCase, res *httptest.ResponseRecorde
 }
 
 func TestUbiquityResource(t *testing.T) {
-	db, err := geoip2.Open("../GeoLite2-City.mmdb")
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
-	ServeUbiquityResource(router.Group("/"), db)
+	dbs := database.NewSet()
+	dbtest.Load(t, dbs.City)
+	ServeUbiquityResource(router.Group("/"), locator.New(dbs))
 
-	kdeDotOrg := `
+	vienna := `
 <Response>
 <script/>
-<Ip>91.189.93.5</Ip>
+<Ip>192.0.2.1</Ip>
 <Status>OK</Status>
-<CountryCode>GB</CountryCode>
+<CountryCode>AT</CountryCode>
 <CountryCode3/>
+<CountryName>Austria</CountryName>
+<RegionCode>9</RegionCode>
+<RegionName>Vienna</RegionName>
+<City>Vienna</City>
+<ZipPostalCode>1010</ZipPostalCode>
+<Latitude>48.2064</Latitude>
+<Longitude>16.3707</Longitude>
+<AreaCode>0</AreaCode>
+<TimeZone>Europe/Vienna</TimeZone>
+</Response>`
+	noCity := `
+<Response>
+<Ip>198.51.100.1</Ip>
+<Status>OK</Status>
+<CountryCode>GB</CountryCode>
 <CountryName>United Kingdom</CountryName>
 <RegionCode>ENG</RegionCode>
 <RegionName>England</RegionName>
-<City>London</City>
-<ZipPostalCode>EC2V</ZipPostalCode>
-<Latitude>51.5142</Latitude>
-<Longitude>-0.0931</Longitude>
-<AreaCode>0</AreaCode>
 <TimeZone>Europe/London</TimeZone>
+</Response>`
+	noTimeZone := `
+<Response>
+<Ip>203.0.113.1</Ip>
+<Status>OK</Status>
+<CountryCode>DE</CountryCode>
+<CountryName>Germany</CountryName>
+<RegionCode>BE</RegionCode>
+<RegionName>Land Berlin</RegionName>
+<City>Berlin</City>
+</Response>`
+	noSubdivisions := `
+<Response>
+<Ip>2001:db8::1</Ip>
+<Status>OK</Status>
+<CountryCode>IS</CountryCode>
+<CountryName>Iceland</CountryName>
+<City>Reykjavik</City>
+<TimeZone>Atlantic/Reykjavik</TimeZone>
+</Response>`
+	private := `
+<Response>
+<Ip>10.0.0.1</Ip>
+<Status>OK</Status>
+<CountryCode>AF</CountryCode>
+<CountryName>Afghanistan</CountryName>
+<City>Kabul</City>
+<TimeZone>Asia/Kabul</TimeZone>
+</Response>`
+	unknown := `
+<Response>
+<Ip>172.16.0.1</Ip>
+<Status>OK</Status>
 </Response>`
 	runAPITests(t, []apiTestCase{
-		{"t1 - get", "GET", "/v1/ubiquity", "", http.StatusOK, kdeDotOrg, equalUbiquity},
+		{"t1 - get", "GET", "/v1/ubiquity", "", http.StatusOK, vienna, equalUbiquity},
+		{"t2 - no city", "GET", "/v1/ubiquity?ip=" + dbtest.NoCity, "", http.StatusOK, noCity, equalUbiquity},
+		{"t3 - no time zone", "GET", "/v1/ubiquity?ip=" + dbtest.NoTimeZone, "", http.StatusOK, noTimeZone, equalUbiquity},
+		{"t4 - no subdivisions", "GET", "/v1/ubiquity?ip=" + dbtest.NoSubdivisions, "", http.StatusOK, noSubdivisions, equalUbiquity},
+		{"t5 - private", "GET", "/v1/ubiquity?ip=" + dbtest.Private, "", http.StatusOK, private, equalUbiquity},
+		{"t6 - unknown", "GET", "/v1/ubiquity?ip=" + dbtest.Unknown, "", http.StatusOK, unknown, equalUbiquity},
 	})
 }
+
+func TestUbiquityResourceUnavailable(t *testing.T) {
+	res := testUnavailableAPI(ServeUbiquityResource, "GET", "/v1/ubiquity")
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	assert.Equal(t, "60", res.Header().Get("Retry-After"))
+	equalUbiquity(t, apiTestCase{response: `
+<Response>
+<Ip>192.0.2.1</Ip>
+<Status>ERROR</Status>
+</Response>`}, res)
+}
+
+func TestUbiquityResourceFailure(t *testing.T) {
+	res := testLocatorAPI(ServeUbiquityResource, failingLocator, "GET", "/v1/ubiquity")
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+}
@@ -0,0 +1,156 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package canary
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	geoip2 "github.com/oschwald/geoip2-golang"
+)
+
+// Assertion is a lookup with a known answer. Empty expectations are not
+// checked.
+type Assertion struct {
+	IP       net.IP
+	Country  string
+	TimeZone string
+}
+
+func (a Assertion) String() string {
+	return fmt.Sprintf("%s %s %s", a.IP, a.Country, a.TimeZone)
+}
+
+// Canaries gate the promotion of new databases. A database passes if at
+// least MinPass (0-1) of the Assertions hold.
+type Canaries struct {
+	Assertions []Assertion
+	MinPass    float64
+}
+
+// Parse reads assertions, one per line in the form
+//
+//	<ip> <country iso code> [time zone]
+//
+// Empty lines and lines starting with # are ignored. A country of - skips
+// the country check.
+func Parse(r io.Reader) ([]Assertion, error) {
+	var assertions []Assertion
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) <= 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected <ip> <country> [time zone]", line)
+		}
+		a := Assertion{IP: net.ParseIP(fields[0])}
+		if a.IP == nil {
+			return nil, fmt.Errorf("line %d: invalid IP %q", line, fields[0])
+		}
+		if fields[1] != "-" {
+			a.Country = fields[1]
+		}
+		if len(fields) > 2 {
+			a.TimeZone = fields[2]
+		}
+		assertions = append(assertions, a)
+	}
+	return assertions, scanner.Err()
+}
+
+// Load reads assertions from a file (see Parse).
+func Load(path string) ([]Assertion, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return Parse(file)
+}
+
+// Failure is an assertion that didn't hold.
+type Failure struct {
+	Assertion Assertion
+	Reason    string
+}
+
+func (f Failure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Assertion.IP, f.Reason)
+}
+
+// Run checks all assertions against db and returns the ones that don't hold.
+func (c *Canaries) Run(db *geoip2.Reader) []Failure {
+	var failures []Failure
+	for _, a := range c.Assertions {
+		record, err := db.City(a.IP)
+		if err != nil {
+			failures = append(failures, Failure{a, err.Error()})
+			continue
+		}
+		var reasons []string
+		if len(a.Country) > 0 && record.Country.IsoCode != a.Country {
+			reasons = append(reasons, fmt.Sprintf("expected country %s, got %q",
+				a.Country, record.Country.IsoCode))
+		}
+		if len(a.TimeZone) > 0 && record.Location.TimeZone != a.TimeZone {
+			reasons = append(reasons, fmt.Sprintf("expected time zone %s, got %q",
+				a.TimeZone, record.Location.TimeZone))
+		}
+		if len(reasons) > 0 {
+			failures = append(failures, Failure{a, strings.Join(reasons, "; ")})
+		}
+	}
+	return failures
+}
+
+// Check opens the database at path and runs the canaries against it. Every
+// regression is logged, an error is returned if too many regressed.
+func (c *Canaries) Check(path string) error {
+	if len(c.Assertions) <= 0 {
+		return nil
+	}
+
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	failures := c.Run(db)
+	for _, failure := range failures {
+		log.Printf("Canary regressed in %s: %s", path, failure)
+	}
+
+	passed := float64(len(c.Assertions)-len(failures)) / float64(len(c.Assertions))
+	if passed < c.MinPass {
+		return fmt.Errorf("canaries failed for %s: %d of %d assertions regressed",
+			path, len(failures), len(c.Assertions))
+	}
+	return nil
+}
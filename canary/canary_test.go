@@ -0,0 +1,103 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package canary
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	geoip2 "github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParse(t *testing.T, text string) []Assertion {
+	assertions, err := Parse(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return assertions
+}
+
+func TestParse(t *testing.T) {
+	assertions := mustParse(t, `
+# our servers
+192.0.2.1 AT Europe/Vienna
+198.51.100.1 GB
+
+2001:db8::1 - UTC
+`)
+	assert.Equal(t, []Assertion{
+		{net.ParseIP("192.0.2.1"), "AT", "Europe/Vienna"},
+		{net.ParseIP("198.51.100.1"), "GB", ""},
+		{net.ParseIP("2001:db8::1"), "", "UTC"},
+	}, assertions)
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse(strings.NewReader("192.0.2.1"))
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("192.0.2 AT"))
+	assert.Error(t, err)
+	_, err = Parse(strings.NewReader("192.0.2.1 AT Europe/Vienna extra"))
+	assert.Error(t, err)
+}
+
+func TestRun(t *testing.T) {
+	db, err := geoip2.Open(dbtest.File(t, dbtest.Options{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	c := &Canaries{Assertions: mustParse(t, `
+192.0.2.1 AT Europe/Vienna
+198.51.100.1 GB Europe/Vienna
+172.16.0.1 US
+`)}
+	failures := c.Run(db)
+	assert.Len(t, failures, 2)
+	assert.Equal(t, `198.51.100.1: expected time zone Europe/Vienna, got "Europe/London"`, failures[0].Error())
+	assert.Equal(t, `172.16.0.1: expected country US, got ""`, failures[1].Error())
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip-canary-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dbtest.File(t, dbtest.Options{})
+
+	assertions := mustParse(t, `
+192.0.2.1 AT Europe/Vienna
+198.51.100.1 GB Europe/London
+172.16.0.1 US
+`)
+	assert.Error(t, (&Canaries{Assertions: assertions, MinPass: 1}).Check(path))
+	assert.NoError(t, (&Canaries{Assertions: assertions, MinPass: 0.6}).Check(path))
+	assert.NoError(t, (&Canaries{}).Check(path))
+	assert.Error(t, (&Canaries{Assertions: assertions}).Check(filepath.Join(dir, "missing.mmdb")))
+}
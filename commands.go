@@ -0,0 +1,249 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/apachelogger/geoip-kde-org/inspect"
+	"github.com/apachelogger/geoip-kde-org/updater"
+)
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [command]
+
+Without command the service is started.
+
+Commands:
+  check-config           check the configuration (see -config) and exit
+  db versions            list stored database builds
+  db rollback [version]  switch to version (default: the previous build) and pin it
+  db pin <version>       switch to version and stop updating
+  db unpin               resume updating
+  db import <file>       install a MaxMind tar.gz, a gzipped mmdb or a bare mmdb
+                         as new build, validated like a download
+  db info [file]         print the metadata of file (default: the current build)
+  db verify [file]       check the structure of file (default: the current build)
+  db export [file]       print every network of file (default: the current build)
+                         with its location, -format csv (default) or jsonl
+  db diff <old> <new>    list networks that moved to another country or time zone,
+                         old and new are files or versions (see db versions)
+
+The db commands act on the City edition unless given -edition <edition>
+right after the subcommand (e.g. db versions -edition GeoLite2-ASN).
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+func runCommand(args []string) error {
+	switch args[0] {
+	case "db":
+		return dbCommand(args[1:])
+	case "check-config":
+		// Reaching this means configure found nothing wrong.
+		fmt.Println("Configuration is fine")
+		return nil
+	}
+	return fmt.Errorf("unknown command %q (see -help)", args[0])
+}
+
+func dbCommand(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("db needs a subcommand (see -help)")
+	}
+
+	fs := flag.NewFlagSet("db "+args[0], flag.ContinueOnError)
+	edition := fs.String("edition", cityEdition, "database `edition` to act on")
+	format := fs.String("format", inspect.CSV, "export `format`, csv or jsonl")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	args = append(args[:1], fs.Args()...)
+	if !editions.contains(*edition) {
+		return fmt.Errorf("edition %s is not configured (see -editions)", *edition)
+	}
+
+	// Read-only, no need to lock.
+	path := store.Path(*edition)
+	if len(args) > 1 {
+		path = args[1]
+	}
+	switch args[0] {
+	case "versions":
+		return dbVersions(*edition)
+	case "info":
+		return inspect.Info(path, os.Stdout)
+	case "verify":
+		if err := inspect.Verify(path); err != nil {
+			return fmt.Errorf("%s is broken: %s", path, err)
+		}
+		fmt.Printf("%s is fine\n", path)
+		return nil
+	case "diff":
+		if len(args) < 3 {
+			return fmt.Errorf("db diff needs two builds (see -help)")
+		}
+		summary, err := inspect.Diff(buildPath(*edition, args[1]), buildPath(*edition, args[2]))
+		if err != nil {
+			return err
+		}
+		fmt.Print(summary)
+		return nil
+	case "export":
+		out := bufio.NewWriter(os.Stdout)
+		if err := inspect.Export(path, *format, out); err != nil {
+			return err
+		}
+		return out.Flush()
+	}
+
+	// Don't switch builds under a running update's feet.
+	unlock, err := store.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	switch args[0] {
+	case "rollback":
+		var epoch uint
+		if len(args) > 1 {
+			epoch, err = parseVersion(args[1])
+		} else {
+			epoch, err = store.Previous(*edition)
+		}
+		if err != nil {
+			return err
+		}
+		if err := store.Pin(*edition, epoch); err != nil {
+			return err
+		}
+		fmt.Printf("Switched %s to build %d and pinned it. Running instances pick it up on reload (SIGHUP or POST /reload). Run `db unpin` to resume updates.\n",
+			*edition, epoch)
+		return nil
+	case "pin":
+		if len(args) < 2 {
+			return fmt.Errorf("db pin needs a version (see db versions)")
+		}
+		epoch, err := parseVersion(args[1])
+		if err != nil {
+			return err
+		}
+		if err := store.Pin(*edition, epoch); err != nil {
+			return err
+		}
+		fmt.Printf("Switched %s to build %d and pinned it. Running instances pick it up on reload (SIGHUP or POST /reload).\n",
+			*edition, epoch)
+		return nil
+	case "unpin":
+		return store.Unpin(*edition)
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("db import needs a file (see -help)")
+		}
+		return dbImport(*edition, args[1])
+	}
+	return fmt.Errorf("unknown db subcommand %q (see -help)", args[0])
+}
+
+func dbVersions(edition string) error {
+	versions, err := store.Versions(edition)
+	if err != nil {
+		return err
+	}
+	current, err := store.Current(edition)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	pinned, _, err := store.Pinned(edition)
+	if err != nil {
+		return err
+	}
+
+	for _, epoch := range versions {
+		var flags string
+		if epoch == current {
+			flags += " current"
+		}
+		if epoch == pinned {
+			flags += " pinned"
+		}
+		fmt.Printf("%d%s\n", epoch, flags)
+	}
+	return nil
+}
+
+// Installs a local file as new build of edition, the same way a download
+// would.
+func dbImport(edition string, path string) error {
+	if epoch, pinned, err := store.Pinned(edition); err != nil {
+		return err
+	} else if pinned {
+		return fmt.Errorf("%s is pinned to build %d, run `db unpin` first", edition, epoch)
+	}
+	if err := store.Clean(); err != nil {
+		return err
+	}
+
+	installed, err := store.Install(edition, func(w io.Writer) error {
+		return updater.Import(path, edition, w)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import %s: %s", path, err)
+	}
+	// Upstream doesn't know this build, don't make the next download
+	// conditional on whatever we downloaded before.
+	if err := store.WriteMeta(edition, buildMeta{}); err != nil {
+		return err
+	}
+	if err := store.Prune(edition, *keep); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s as %s. Running instances pick it up on reload.\n", path, installed)
+	return nil
+}
+
+// Path of the stored build build refers to by version, or build as is if
+// it isn't one.
+func buildPath(edition string, build string) string {
+	if epoch, err := strconv.ParseUint(build, 10, 0); err == nil {
+		path := store.VersionPath(edition, uint(epoch))
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return build
+}
+
+func parseVersion(version string) (uint, error) {
+	epoch, err := strconv.ParseUint(version, 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("versions are build epochs (see db versions): %s", err)
+	}
+	return uint(epoch), nil
+}
@@ -0,0 +1,247 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/apachelogger/geoip-kde-org/canary"
+	"github.com/apachelogger/geoip-kde-org/config"
+	"github.com/apachelogger/geoip-kde-org/updater"
+)
+
+var configFile = flag.String("config", os.Getenv("GEOIP_CONFIG"),
+	"TOML `file` of settings named like the flags, environment variables and flags override it")
+
+// The environment variables flags default to. When set they win over the
+// config file. HOST and PORT are left to listenFromEnv, they only set part
+// of -listen.
+var flagEnv = map[string][]string{
+	"route-prefix":      {"GEOIP_ROUTE_PREFIX"},
+	"trusted-proxies":   {"GEOIP_TRUSTED_PROXIES"},
+	"database-dir":      {"GEOIP_DATABASE_DIR"},
+	"provider":          {"GEOIP_PROVIDER"},
+	"geoip-conf":        {"GEOIP_CONF"},
+	"download-url":      {"GEOIP_DOWNLOAD_URL"},
+	"account-id":        {"GEOIP_ACCOUNT_ID"},
+	"license-key":       {"GEOIP_LICENSE_KEY"},
+	"dbip-url":          {"GEOIP_DBIP_URL"},
+	"s3-endpoint":       {"GEOIP_S3_ENDPOINT"},
+	"s3-insecure":       {"GEOIP_S3_INSECURE"},
+	"s3-region":         {"GEOIP_S3_REGION"},
+	"s3-bucket":         {"GEOIP_S3_BUCKET"},
+	"s3-prefix":         {"GEOIP_S3_PREFIX"},
+	"s3-access-key":     {"GEOIP_S3_ACCESS_KEY", "AWS_ACCESS_KEY_ID"},
+	"s3-secret-key":     {"GEOIP_S3_SECRET_KEY", "AWS_SECRET_ACCESS_KEY"},
+	"replication-token": {"GEOIP_REPLICATION_TOKEN"},
+	"leader-url":        {"GEOIP_LEADER_URL"},
+}
+
+// Whether an environment variable of flag name is set.
+func envSet(name string) bool {
+	for _, key := range flagEnv[name] {
+		if len(os.Getenv(key)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// The flags set on the command line, in the config file or through their
+// environment variables. A GeoIP.conf only fills in the others.
+func explicitlySet() map[string]bool {
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	for name := range flagEnv {
+		set[name] = set[name] || envSet(name)
+	}
+	return set
+}
+
+// Settings the config file had invalid values for. Those are zero now,
+// checkSettings doesn't complain about them a second time.
+var invalidSettings = map[string]bool{}
+
+// Applies the -config file, if any. Flags passed on the command line and
+// environment variables win over it.
+func loadConfig() error {
+	invalidSettings = map[string]bool{}
+	if len(*configFile) <= 0 {
+		return nil
+	}
+	values, err := config.Load(*configFile)
+	if err != nil {
+		return err
+	}
+	if _, ok := values["config"]; ok {
+		return fmt.Errorf("%s: config can't point to another config", *configFile)
+	}
+	flagged := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { flagged[f.Name] = true })
+	err = config.Apply(flag.CommandLine, values, func(name string) bool {
+		return flagged[name] || envSet(name)
+	})
+	if applyErr, ok := err.(*config.Error); ok {
+		for _, name := range applyErr.Invalid {
+			invalidSettings[name] = true
+		}
+	}
+	if !flagged["listen"] {
+		listenFromEnv()
+	}
+	return err
+}
+
+// Overrides the host and port of -listen from the config file with HOST
+// and PORT, each only their own part so that e.g. PORT keeps the host.
+func listenFromEnv() {
+	host, port, err := net.SplitHostPort(*listen)
+	if err != nil {
+		return // checkSettings complains
+	}
+	*listen = net.JoinHostPort(envOr("HOST", host), envOr("PORT", port))
+}
+
+// Puts the configuration from all sources together and checks it. All
+// problems are reported at once.
+func configure() error {
+	var errs []error
+	if err := loadConfig(); err != nil {
+		errs = append(errs, err)
+	}
+	store.Dir = *databaseDir
+	for _, step := range []func() error{loadGeoIPConf, selectProvider, checkEditions} {
+		if err := step(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// The GeoIP.conf may have moved the store. Staging next to it keeps
+	// leftovers out of the shared temporary directory, Clean gets them.
+	updater.StagingDir = store.StagingDir()
+	return joinErrors(append(errs, checkSettings()...))
+}
+
+// Checks the values of the settings that flag parsing doesn't.
+func checkSettings() []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	// Whether the setting name is out of range, unless it was invalid to
+	// begin with.
+	bad := func(name string, outOfRange bool) bool {
+		return outOfRange && !invalidSettings[name]
+	}
+	if bad("update-interval", *updateInterval <= 0) {
+		fail("-update-interval must be positive, is %s", *updateInterval)
+	}
+	if bad("update-jitter", *updateJitter < 0) {
+		fail("-update-jitter must not be negative, is %s", *updateJitter)
+	}
+	if bad("retention", *retention <= 0) {
+		fail("-retention must be positive, is %s", *retention)
+	}
+	if bad("shutdown-timeout", *shutdownTimeout <= 0) {
+		fail("-shutdown-timeout must be positive, is %s", *shutdownTimeout)
+	}
+	if bad("keep", *keep < 0) {
+		fail("-keep must not be negative, is %d", *keep)
+	}
+	if bad("canary-threshold", canaries.MinPass < 0 || canaries.MinPass > 1) {
+		fail("-canary-threshold must be between 0 and 1, is %g", canaries.MinPass)
+	}
+	if len(*canaryFile) > 0 {
+		if _, err := canary.Load(*canaryFile); err != nil {
+			fail("-canaries: %s", err)
+		}
+	}
+
+	checkURL := func(name string, value string) {
+		if u, err := url.Parse(value); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) <= 0 {
+			fail("-%s must be an http or https URL, is %q", name, value)
+		}
+	}
+	checkURL("download-url", maxmind.BaseURL)
+	checkURL("dbip-url", dbip.BaseURL)
+	if len(leader.URL) > 0 {
+		checkURL("leader-url", leader.URL)
+	}
+	if strings.Contains(s3.Endpoint, "://") {
+		fail("-s3-endpoint takes a host[:port], not a URL (see -s3-insecure for plain HTTP)")
+	}
+	switch provider {
+	case maxmind:
+		if len(maxmind.AccountID) <= 0 || len(maxmind.LicenseKey) <= 0 {
+			fail("-provider maxmind needs an -account-id and a -license-key (or a -geoip-conf with them)")
+		}
+		for _, edition := range editions {
+			if updater.IsDBIPEdition(edition) {
+				fail("-provider maxmind doesn't provide %s, -provider dbip does", edition)
+			}
+		}
+	case dbip:
+		for _, edition := range editions {
+			if !updater.IsDBIPEdition(edition) {
+				fail("-provider dbip only provides dbip-*-lite editions, not %s", edition)
+			}
+		}
+	case leader:
+		if len(leader.URL) <= 0 {
+			fail("-provider leader needs a -leader-url")
+		}
+	case s3:
+		if len(s3.Bucket) <= 0 {
+			fail("-provider s3 needs an -s3-bucket")
+		}
+	}
+	if *s3Publish && len(s3.Bucket) <= 0 {
+		fail("-s3-publish needs an -s3-bucket")
+	}
+
+	checkAddress := func(name string, value string) {
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			fail("-%s must be a host:port address: %s", name, err)
+		}
+	}
+	checkAddress("listen", *listen)
+	if len(*adminListen) > 0 {
+		checkAddress("admin-listen", *adminListen)
+	}
+	if len(*routePrefix) > 0 && !strings.HasPrefix(*routePrefix, "/") {
+		fail("-route-prefix must start with /, is %q", *routePrefix)
+	}
+	if len(*trustedProxies) > 0 {
+		for _, proxy := range strings.Split(*trustedProxies, ",") {
+			if net.ParseIP(proxy) == nil {
+				if _, _, err := net.ParseCIDR(proxy); err != nil {
+					fail("-trusted-proxies entry %q is neither an IP nor a CIDR", proxy)
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,142 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package config reads settings files. Settings are named like the command
+// line flags they stand for and are applied through them, so a file is
+// merely another way of passing flags:
+//
+//	provider = "dbip"
+//	editions = ["dbip-city-lite", "dbip-country-lite"]
+//	update-interval = "12h"
+//
+//	[s3]
+//	bucket = "geoip"  # same as s3-bucket = "geoip"
+package config
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Load reads the TOML file at path into flag values by flag name. Tables
+// prefix the names of their keys (e.g. bucket in [s3] is s3-bucket), lists
+// become comma separated values.
+func Load(path string) (map[string]string, error) {
+	var raw map[string]interface{}
+	if _, err := toml.DecodeFile(path, &raw); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	if err := flatten("", raw, values); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return values, nil
+}
+
+func flatten(prefix string, raw map[string]interface{}, values map[string]string) error {
+	for key, value := range raw {
+		name := prefix + key
+		if table, ok := value.(map[string]interface{}); ok {
+			if err := flatten(name+"-", table, values); err != nil {
+				return err
+			}
+			continue
+		}
+		str, err := toString(value)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+		if _, ok := values[name]; ok {
+			return fmt.Errorf("%s is set twice", name)
+		}
+		values[name] = str
+	}
+	return nil
+}
+
+func toString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, int64, float64:
+		return fmt.Sprint(v), nil
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			str, ok := item.(string)
+			if !ok {
+				return "", fmt.Errorf("lists may only hold strings, not %T", item)
+			}
+			if strings.Contains(str, ",") {
+				return "", fmt.Errorf("list item %q contains a comma", str)
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported type %T", value)
+}
+
+// Error lists everything Apply ran into.
+type Error struct {
+	// Invalid names the settings whose values got rejected. flag leaves
+	// them zero rather than at their previous value.
+	Invalid  []string
+	messages []string
+}
+
+func (e *Error) Error() string {
+	return strings.Join(e.messages, "; ")
+}
+
+// Apply sets the flags of fs to values. Values for which skip returns true
+// are left alone (e.g. because the flag got passed on the command line).
+// Unknown flags and invalid values are errors, all of them are reported in
+// an *Error.
+func Apply(fs *flag.FlagSet, values map[string]string, skip func(name string) bool) error {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	e := &Error{}
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			e.messages = append(e.messages, fmt.Sprintf("unknown setting %s", name))
+			continue
+		}
+		if skip != nil && skip(name) {
+			continue
+		}
+		if err := fs.Set(name, values[name]); err != nil {
+			e.Invalid = append(e.Invalid, name)
+			e.messages = append(e.messages, fmt.Sprintf("invalid %s %q: %s", name, values[name], err))
+		}
+	}
+	if len(e.messages) > 0 {
+		return e
+	}
+	return nil
+}
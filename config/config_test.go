@@ -0,0 +1,114 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "geoip.toml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	values, err := Load(writeConfig(t, `
+provider = "dbip"
+editions = ["dbip-city-lite", "dbip-country-lite"]
+update-interval = "12h"
+keep = 3
+canary-threshold = 0.5
+watch = true
+
+[s3]
+bucket = "geoip"
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"provider":         "dbip",
+		"editions":         "dbip-city-lite,dbip-country-lite",
+		"update-interval":  "12h",
+		"keep":             "3",
+		"canary-threshold": "0.5",
+		"watch":            "true",
+		"s3-bucket":        "geoip",
+	}, values)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, content := range []string{
+		`provider = `,
+		`editions = [1, 2]`,
+		`editions = ["a,b"]`,
+		`at = 1979-05-27T07:32:00Z`,
+		"s3-bucket = \"a\"\n[s3]\nbucket = \"b\"",
+	} {
+		_, err := Load(writeConfig(t, content))
+		assert.Error(t, err, content)
+	}
+	_, err := Load(filepath.Join(t.TempDir(), "missing.toml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestApply(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	provider := fs.String("provider", "maxmind", "")
+	interval := fs.Duration("update-interval", time.Hour, "")
+	keep := fs.Int("keep", 2, "")
+
+	err := Apply(fs, map[string]string{
+		"provider":        "dbip",
+		"update-interval": "12h",
+		"keep":            "3",
+	}, func(name string) bool { return name == "keep" })
+	assert.NoError(t, err)
+	assert.Equal(t, "dbip", *provider)
+	assert.Equal(t, 12*time.Hour, *interval)
+	assert.Equal(t, 2, *keep)
+}
+
+func TestApplyInvalid(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Duration("update-interval", time.Hour, "")
+	fs.Int("keep", 2, "")
+
+	err := Apply(fs, map[string]string{
+		"update-interval": "often",
+		"keep":            "3",
+		"bogus":           "1",
+	}, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown setting bogus")
+	assert.Contains(t, err.Error(), "invalid update-interval")
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, []string{"update-interval"}, err.(*Error).Invalid)
+	}
+}
@@ -0,0 +1,307 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+// Resets all flags to their defaults and parses args as if the process had
+// been started with them in env. Flags take their defaults from the
+// environment on start, for those in flagEnv that is done here again.
+func commandLine(t *testing.T, env map[string]string, args ...string) {
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+	old := flag.CommandLine
+	values := map[string]string{}
+	fs := flag.NewFlagSet("geoip-kde-org", flag.ContinueOnError)
+	old.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "test.") {
+			return // go test's own
+		}
+		values[f.Name] = f.Value.String()
+		f.Value.Set(f.DefValue)
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	oldStore, oldEditions, oldExplicit := store, editions, explicitEditions
+	t.Cleanup(func() {
+		flag.CommandLine = old
+		for name, value := range values {
+			old.Lookup(name).Value.Set(value)
+		}
+		store, editions, explicitEditions = oldStore, oldEditions, oldExplicit
+	})
+
+	flag.CommandLine = fs
+	invalidSettings = map[string]bool{}
+	for name, keys := range flagEnv {
+		for _, key := range keys {
+			if value := os.Getenv(key); len(value) > 0 {
+				fs.Lookup(name).Value.Set(value)
+				break
+			}
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	store = &storage.Store{Dir: *databaseDir}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	const file = `
+route-prefix = "/file"
+listen = "127.0.0.1:9000"
+[s3]
+access-key = "file"
+`
+	for _, tc := range []struct {
+		name string
+		env  map[string]string
+		args []string
+		want map[string]string
+	}{
+		{
+			name: "file",
+			want: map[string]string{"route-prefix": "/file", "listen": "127.0.0.1:9000", "s3-access-key": "file"},
+		},
+		{
+			name: "env over file",
+			env:  map[string]string{"GEOIP_ROUTE_PREFIX": "/env", "AWS_ACCESS_KEY_ID": "aws"},
+			want: map[string]string{"route-prefix": "/env", "s3-access-key": "aws"},
+		},
+		{
+			name: "flags over env",
+			env:  map[string]string{"GEOIP_ROUTE_PREFIX": "/env", "PORT": "8081"},
+			args: []string{"-route-prefix", "/flag", "-listen", ":7000"},
+			want: map[string]string{"route-prefix": "/flag", "listen": ":7000"},
+		},
+		{
+			name: "PORT keeps the host",
+			env:  map[string]string{"PORT": "8081"},
+			want: map[string]string{"listen": "127.0.0.1:8081"},
+		},
+		{
+			name: "HOST keeps the port",
+			env:  map[string]string{"HOST": "0.0.0.0"},
+			want: map[string]string{"listen": "0.0.0.0:9000"},
+		},
+		{
+			name: "HOST and PORT",
+			env:  map[string]string{"HOST": "::1", "PORT": "8081"},
+			want: map[string]string{"listen": "[::1]:8081"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeFile(t, "geoip.toml", file)
+			commandLine(t, tc.env, append([]string{"-config", path}, tc.args...)...)
+			assert.NoError(t, loadConfig())
+			for name, want := range tc.want {
+				assert.Equal(t, want, flag.Lookup(name).Value.String(), name)
+			}
+		})
+	}
+}
+
+func TestCheckSettings(t *testing.T) {
+	credentials := []string{"-account-id", "42", "-license-key", "secret"}
+	for _, tc := range []struct {
+		name string
+		args []string
+		want []string // one error containing each
+	}{
+		{
+			name: "defaults",
+			want: []string{"-provider maxmind needs an -account-id and a -license-key"},
+		},
+		{
+			name: "durations",
+			args: append(credentials, "-update-interval", "0", "-update-jitter", "-1s", "-retention", "0", "-shutdown-timeout", "0"),
+			want: []string{"-update-interval", "-update-jitter", "-retention", "-shutdown-timeout"},
+		},
+		{
+			name: "canaries",
+			args: append(credentials, "-keep", "-1", "-canary-threshold", "1.5", "-canaries", "/nonexistent"),
+			want: []string{"-keep", "-canary-threshold", "-canaries"},
+		},
+		{
+			name: "urls",
+			args: append(credentials, "-download-url", "ftp://example.com", "-dbip-url", "example.com", "-leader-url", "http://"),
+			want: []string{"-download-url", "-dbip-url", "-leader-url"},
+		},
+		{
+			name: "maxmind",
+			args: []string{"-license-key", "secret", "-editions", "dbip-city-lite"},
+			want: []string{"needs an -account-id", "-provider maxmind doesn't provide dbip-city-lite"},
+		},
+		{
+			name: "dbip",
+			args: []string{"-provider", "dbip", "-editions", "GeoLite2-City,dbip-asn-lite"},
+			want: []string{"-provider dbip only provides dbip-*-lite editions, not GeoLite2-City"},
+		},
+		{
+			name: "s3",
+			args: []string{"-provider", "s3", "-s3-endpoint", "https://s3.example.com"},
+			want: []string{"-s3-endpoint", "-provider s3 needs an -s3-bucket"},
+		},
+		{
+			name: "leader",
+			args: []string{"-provider", "leader"},
+			want: []string{"-provider leader needs a -leader-url"},
+		},
+		{
+			name: "publish",
+			args: append(credentials, "-s3-publish"),
+			want: []string{"-s3-publish needs an -s3-bucket"},
+		},
+		{
+			name: "listeners",
+			args: append(credentials, "-listen", "8080", "-admin-listen", "localhost", "-route-prefix", "geoip", "-trusted-proxies", "10.0.0.1,proxy"),
+			want: []string{"-listen", "-admin-listen", "-route-prefix", `"proxy"`},
+		},
+		{
+			name: "valid maxmind",
+			args: credentials,
+		},
+		{
+			name: "valid dbip",
+			args: []string{"-provider", "dbip", "-editions", "dbip-city-lite,dbip-asn-lite"},
+		},
+		{
+			name: "valid s3",
+			args: []string{"-provider", "s3", "-s3-bucket", "geoip", "-listen", "127.0.0.1:8080", "-route-prefix", "/geoip", "-trusted-proxies", "10.0.0.1,192.0.2.0/24"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			commandLine(t, nil, tc.args...)
+			assertSettings(t, tc.want)
+		})
+	}
+}
+
+// Checks that checkSettings reports one error containing each of want.
+func assertSettings(t *testing.T, want []string) {
+	oldProvider := provider
+	defer func() { provider = oldProvider }()
+	assert.NoError(t, selectProvider())
+
+	errs := checkSettings()
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	assert.Len(t, errs, len(want), strings.Join(messages, "\n"))
+	for _, w := range want {
+		found := false
+		for _, message := range messages {
+			found = found || strings.Contains(message, w)
+		}
+		assert.True(t, found, "no error about %s in %q", w, messages)
+	}
+}
+
+func TestCheckSettingsSkipsInvalidConfig(t *testing.T) {
+	path := writeFile(t, "geoip.toml", "update-interval = \"x\"\nkeep = -1\n")
+	commandLine(t, nil, "-config", path, "-account-id", "42", "-license-key", "secret")
+	err := loadConfig()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid update-interval")
+	}
+	// Only the valid but out of range keep.
+	assertSettings(t, []string{"-keep must not be negative"})
+}
+
+func TestGeoIPConfPrecedence(t *testing.T) {
+	const conf = `
+AccountID 42
+LicenseKey conf
+EditionIDs GeoLite2-City GeoLite2-ASN
+DatabaseDirectory /var/lib/geoip
+`
+	for _, tc := range []struct {
+		name       string
+		file       string
+		env        map[string]string
+		args       []string
+		accountID  string
+		licenseKey string
+		dir        string
+		editions   string
+	}{
+		{
+			name:      "conf",
+			accountID: "42", licenseKey: "conf", dir: "/var/lib/geoip", editions: "GeoLite2-City,GeoLite2-ASN",
+		},
+		{
+			name:      "env over conf",
+			env:       map[string]string{"GEOIP_ACCOUNT_ID": "1", "GEOIP_DATABASE_DIR": "/env"},
+			accountID: "1", licenseKey: "conf", dir: "/env", editions: "GeoLite2-City,GeoLite2-ASN",
+		},
+		{
+			name:      "file over conf",
+			file:      "account-id = \"2\"\ndatabase-dir = \"/file\"\neditions = [\"GeoLite2-City\"]\n",
+			accountID: "2", licenseKey: "conf", dir: "/file", editions: "GeoLite2-City",
+		},
+		{
+			name:      "env over file over conf",
+			file:      "account-id = \"2\"\ndatabase-dir = \"/file\"\n",
+			env:       map[string]string{"GEOIP_ACCOUNT_ID": "1"},
+			accountID: "1", licenseKey: "conf", dir: "/file", editions: "GeoLite2-City,GeoLite2-ASN",
+		},
+		{
+			name:      "flags over conf",
+			args:      []string{"-account-id", "1", "-database-dir", "/flag", "-editions", "GeoLite2-City"},
+			accountID: "1", licenseKey: "conf", dir: "/flag", editions: "GeoLite2-City",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args := append([]string{"-geoip-conf", writeFile(t, "GeoIP.conf", conf)}, tc.args...)
+			if len(tc.file) > 0 {
+				args = append(args, "-config", writeFile(t, "geoip.toml", tc.file))
+			}
+			commandLine(t, tc.env, args...)
+			assert.NoError(t, loadConfig())
+			store.Dir = *databaseDir
+			assert.NoError(t, loadGeoIPConf())
+			assert.Equal(t, tc.accountID, maxmind.AccountID)
+			assert.Equal(t, tc.licenseKey, maxmind.LicenseKey)
+			assert.Equal(t, tc.dir, store.Dir)
+			assert.Equal(t, tc.editions, editions.String())
+		})
+	}
+}
@@ -0,0 +1,114 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"errors"
+	"sync"
+
+	geoip2 "github.com/oschwald/geoip2-golang"
+)
+
+// ErrUnavailable is returned while no database has been loaded yet.
+var ErrUnavailable = errors.New("database unavailable")
+
+// A loaded reader and everyone currently using it.
+type generation struct {
+	reader *geoip2.Reader
+	users  sync.WaitGroup
+}
+
+// Database holds the geoip2.Reader the resources look things up in. It may
+// start out empty (e.g. on first start while the download is still going)
+// and have its reader replaced at any time. Replaced readers are only closed
+// once every user has released them.
+type Database struct {
+	mu      sync.RWMutex
+	current *generation
+}
+
+// Load opens the database at path and makes it the current one. The
+// previous reader, if any, is closed in the background once released by
+// all its users.
+func (d *Database) Load(path string) error {
+	reader, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	old := d.current
+	d.current = &generation{reader: reader}
+	d.mu.Unlock()
+
+	if old != nil {
+		go retire(old)
+	}
+	return nil
+}
+
+// Acquire returns the current reader and a func to release it again. The
+// reader stays open until released, even if it gets replaced in the
+// meantime. Returns ErrUnavailable while nothing is loaded.
+func (d *Database) Acquire() (*geoip2.Reader, func(), error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.current == nil {
+		return nil, nil, ErrUnavailable
+	}
+	// Under the lock so a generation can't gain users after it got
+	// replaced, which is what makes waiting on them in retire safe.
+	gen := d.current
+	gen.users.Add(1)
+	return gen.reader, gen.users.Done, nil
+}
+
+// Loaded returns whether a reader is loaded.
+func (d *Database) Loaded() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.current != nil
+}
+
+// Close unloads the current reader and closes it once released by all its
+// users. The Database is empty afterwards.
+func (d *Database) Close() error {
+	d.mu.Lock()
+	old := d.current
+	d.current = nil
+	d.mu.Unlock()
+
+	if old == nil {
+		return nil
+	}
+	return retire(old)
+}
+
+// Called after a reader got closed. Lets tests observe retirement without
+// touching the closed reader.
+var retired = func(reader *geoip2.Reader) {}
+
+func retire(gen *generation) error {
+	gen.users.Wait()
+	err := gen.reader.Close()
+	retired(gen.reader)
+	return err
+}
@@ -0,0 +1,161 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	geoip2 "github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+)
+
+// Writes a database with 192.0.2.0/24 in timeZone.
+func writeMMDB(t *testing.T, path string, timeZone string) {
+	dbtest.WriteFile(t, path, dbtest.Options{Records: dbtest.Records{
+		"192.0.2.0/24": {"location": mmdbtype.Map{"time_zone": mmdbtype.String(timeZone)}},
+	}})
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "geoip-database-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func lookupTimeZone(t *testing.T, reader *geoip2.Reader) string {
+	record, err := reader.City(net.ParseIP("192.0.2.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return record.Location.TimeZone
+}
+
+func TestDatabase(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "GeoLite2-City.mmdb")
+
+	db := &Database{}
+	assert.False(t, db.Loaded())
+	_, _, err := db.Acquire()
+	assert.Equal(t, ErrUnavailable, err)
+	assert.Error(t, db.Load(path))
+	assert.False(t, db.Loaded())
+
+	writeMMDB(t, path, "Europe/Vienna")
+	assert.NoError(t, db.Load(path))
+	assert.True(t, db.Loaded())
+	reader, release, err := db.Acquire()
+	assert.NoError(t, err)
+	assert.Equal(t, "Europe/Vienna", lookupTimeZone(t, reader))
+	release()
+
+	assert.NoError(t, db.Close())
+	assert.False(t, db.Loaded())
+	assert.NoError(t, db.Close())
+}
+
+func TestDatabaseSwap(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	oldPath := filepath.Join(dir, "old.mmdb")
+	newPath := filepath.Join(dir, "new.mmdb")
+	writeMMDB(t, oldPath, "Europe/Vienna")
+	writeMMDB(t, newPath, "Europe/London")
+
+	closed := make(chan *geoip2.Reader, 1)
+	retired = func(reader *geoip2.Reader) { closed <- reader }
+	defer func() { retired = func(*geoip2.Reader) {} }()
+
+	db := &Database{}
+	defer db.Close()
+	assert.NoError(t, db.Load(oldPath))
+	inflight, release, err := db.Acquire()
+	assert.NoError(t, err)
+
+	assert.NoError(t, db.Load(newPath))
+	reader, releaseNew, err := db.Acquire()
+	assert.NoError(t, err)
+	assert.Equal(t, "Europe/London", lookupTimeZone(t, reader))
+	releaseNew()
+
+	// The in-flight request still has a working old reader...
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, "Europe/Vienna", lookupTimeZone(t, inflight))
+	select {
+	case <-closed:
+		t.Fatal("reader closed while in use")
+	default:
+	}
+	// ...until it's done with it.
+	release()
+	select {
+	case reader := <-closed:
+		assert.Equal(t, inflight, reader)
+	case <-time.After(time.Second):
+		t.Fatal("reader not closed after release")
+	}
+}
+
+func TestDatabaseConcurrentSwap(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "GeoLite2-City.mmdb")
+	writeMMDB(t, path, "Europe/Vienna")
+
+	db := &Database{}
+	defer db.Close()
+	assert.NoError(t, db.Load(path))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				reader, release, err := db.Acquire()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				// Never a closed reader.
+				if _, err := reader.City(net.ParseIP("192.0.2.1")); err != nil {
+					t.Error(err)
+				}
+				release()
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		assert.NoError(t, db.Load(path))
+	}
+	wg.Wait()
+}
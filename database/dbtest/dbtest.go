@@ -0,0 +1,192 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package dbtest writes small databases with known records so code using
+// databases can be tested without the real, ever changing, data.
+package dbtest
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Addresses with known records in the default database.
+const (
+	// Vienna has a full record: AT, Vienna (9), Vienna, 1010, Europe/Vienna.
+	Vienna = "192.0.2.1"
+	// NoCity is in GB, England (ENG) and Europe/London but no city.
+	NoCity = "198.51.100.1"
+	// NoTimeZone is in Berlin, DE, Land Berlin (BE) but has no time zone.
+	NoTimeZone = "203.0.113.1"
+	// NoSubdivisions is in Reykjavik, IS and Atlantic/Reykjavik without any
+	// subdivisions.
+	NoSubdivisions = "2001:db8::1"
+	// Private is in a private range, pretending to be in Kabul, AF,
+	// Asia/Kabul (a time zone with an odd offset).
+	Private = "10.0.0.1"
+	// Unknown is in a private range the database knows nothing about.
+	Unknown = "172.16.0.1"
+)
+
+// BuildEpoch of the default database.
+const BuildEpoch = 1523952000
+
+// Records are database records by network (CIDR).
+type Records map[string]mmdbtype.Map
+
+// Options describe a database to write. The zero value is the default
+// database: a GeoLite2-City with the records of the addresses above.
+type Options struct {
+	// DatabaseType defaults to GeoLite2-City.
+	DatabaseType string
+	// BuildEpoch defaults to the BuildEpoch constant.
+	BuildEpoch int64
+	// Records default to the records of the addresses above.
+	Records Records
+}
+
+func names(en string) mmdbtype.Map {
+	return mmdbtype.Map{"en": mmdbtype.String(en)}
+}
+
+func place(isoCode string, name string) mmdbtype.Map {
+	return mmdbtype.Map{"iso_code": mmdbtype.String(isoCode), "names": names(name)}
+}
+
+var records = Records{
+	// RFC 5737 TEST-NET-1
+	"192.0.2.0/24": {
+		"country":      place("AT", "Austria"),
+		"subdivisions": mmdbtype.Slice{place("9", "Vienna")},
+		"city":         mmdbtype.Map{"names": names("Vienna")},
+		"postal":       mmdbtype.Map{"code": mmdbtype.String("1010")},
+		"location": mmdbtype.Map{
+			"latitude":  mmdbtype.Float64(48.2064),
+			"longitude": mmdbtype.Float64(16.3707),
+			"time_zone": mmdbtype.String("Europe/Vienna"),
+		},
+	},
+	// RFC 5737 TEST-NET-2
+	"198.51.100.0/24": {
+		"country":      place("GB", "United Kingdom"),
+		"subdivisions": mmdbtype.Slice{place("ENG", "England")},
+		"location":     mmdbtype.Map{"time_zone": mmdbtype.String("Europe/London")},
+	},
+	// RFC 5737 TEST-NET-3
+	"203.0.113.0/24": {
+		"country":      place("DE", "Germany"),
+		"subdivisions": mmdbtype.Slice{place("BE", "Land Berlin")},
+		"city":         mmdbtype.Map{"names": names("Berlin")},
+	},
+	// RFC 3849
+	"2001:db8::/32": {
+		"country":  place("IS", "Iceland"),
+		"city":     mmdbtype.Map{"names": names("Reykjavik")},
+		"location": mmdbtype.Map{"time_zone": mmdbtype.String("Atlantic/Reykjavik")},
+	},
+	// RFC 1918
+	"10.0.0.0/8": {
+		"country":  place("AF", "Afghanistan"),
+		"city":     mmdbtype.Map{"names": names("Kabul")},
+		"location": mmdbtype.Map{"time_zone": mmdbtype.String("Asia/Kabul")},
+	},
+}
+
+// Write writes the database described by o to w.
+func Write(w io.Writer, o Options) error {
+	if len(o.DatabaseType) <= 0 {
+		o.DatabaseType = "GeoLite2-City"
+	}
+	if o.BuildEpoch == 0 {
+		o.BuildEpoch = BuildEpoch
+	}
+	if o.Records == nil {
+		o.Records = records
+	}
+	tree, err := mmdbwriter.New(mmdbwriter.Options{
+		DatabaseType: o.DatabaseType,
+		Description:  map[string]string{"en": "dbtest " + o.DatabaseType},
+		Languages:    []string{"en"},
+		BuildEpoch:   o.BuildEpoch,
+		// All our networks are reserved ones.
+		IncludeReservedNetworks: true,
+	})
+	if err != nil {
+		return err
+	}
+	for cidr, record := range o.Records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		if err := tree.Insert(network, record); err != nil {
+			return err
+		}
+	}
+	_, err = tree.WriteTo(w)
+	return err
+}
+
+// WriteFile writes the database described by o to path.
+func WriteFile(t testing.TB, path string, o Options) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := Write(file, o); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// File writes the database described by o to a temporary file that is
+// removed once the test is done and returns its path.
+func File(t testing.TB, o Options) string {
+	file, err := ioutil.TempFile("", "dbtest-*.mmdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	t.Cleanup(func() { os.Remove(file.Name()) })
+	WriteFile(t, file.Name(), o)
+	return file.Name()
+}
+
+// Loader is what loads databases, i.e. a *database.Database. Not referred
+// to directly so the database package may test with dbtest as well.
+type Loader interface {
+	Load(path string) error
+	Close() error
+}
+
+// Load loads the default database into db, closed again once the test is
+// done.
+func Load(t testing.TB, db Loader) {
+	if err := db.Load(File(t, Options{})); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+}
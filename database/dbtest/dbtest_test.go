@@ -0,0 +1,70 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package dbtest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/inspect"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	geoip2 "github.com/oschwald/geoip2-golang"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	assert.NoError(t, inspect.Verify(File(t, Options{})))
+}
+
+func TestFileOptions(t *testing.T) {
+	path := File(t, Options{
+		DatabaseType: "GeoLite2-ASN",
+		BuildEpoch:   1524556800,
+		Records: Records{
+			"192.0.2.0/24": {"autonomous_system_number": mmdbtype.Uint32(64496)},
+		},
+	})
+	reader, err := geoip2.Open(path)
+	assert.NoError(t, err)
+	defer reader.Close()
+	assert.Equal(t, "GeoLite2-ASN", reader.Metadata().DatabaseType)
+	assert.Equal(t, uint(1524556800), reader.Metadata().BuildEpoch)
+	record, err := reader.ASN(net.ParseIP(Vienna))
+	assert.NoError(t, err)
+	assert.Equal(t, uint(64496), record.AutonomousSystemNumber)
+}
+
+func TestLoad(t *testing.T) {
+	db := &database.Database{}
+	Load(t, db)
+	reader, release, err := db.Acquire()
+	assert.NoError(t, err)
+	defer release()
+	assert.Equal(t, uint(BuildEpoch), reader.Metadata().BuildEpoch)
+
+	record, err := reader.City(net.ParseIP(Vienna))
+	assert.NoError(t, err)
+	assert.Equal(t, "Europe/Vienna", record.Location.TimeZone)
+	record, err = reader.City(net.ParseIP(Unknown))
+	assert.NoError(t, err)
+	assert.Empty(t, record.Country.IsoCode)
+}
@@ -0,0 +1,63 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import "strings"
+
+// Set groups the databases of the different kinds of editions. All of them
+// are always set but may be empty (see Database.Loaded).
+type Set struct {
+	City    *Database
+	Country *Database
+	ASN     *Database
+}
+
+// NewSet creates a Set of empty databases.
+func NewSet() *Set {
+	return &Set{City: &Database{}, Country: &Database{}, ASN: &Database{}}
+}
+
+// ForEdition returns the database for editions of the kind of edition (e.g.
+// City for GeoLite2-City, GeoIP2-City and dbip-city-lite), nil for unknown
+// kinds.
+func (s *Set) ForEdition(edition string) *Database {
+	kind := strings.TrimSuffix(strings.ToLower(edition), "-lite")
+	switch {
+	case strings.HasSuffix(kind, "-city"):
+		return s.City
+	case strings.HasSuffix(kind, "-country"):
+		return s.Country
+	case strings.HasSuffix(kind, "-asn"):
+		return s.ASN
+	}
+	return nil
+}
+
+// Close closes all databases.
+func (s *Set) Close() error {
+	var err error
+	for _, db := range []*Database{s.City, s.Country, s.ASN} {
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetForEdition(t *testing.T) {
+	s := NewSet()
+	defer s.Close()
+	assert.Same(t, s.City, s.ForEdition("GeoLite2-City"))
+	assert.Same(t, s.City, s.ForEdition("GeoIP2-City"))
+	assert.Same(t, s.Country, s.ForEdition("GeoLite2-Country"))
+	assert.Same(t, s.ASN, s.ForEdition("GeoLite2-ASN"))
+	assert.Same(t, s.City, s.ForEdition("dbip-city-lite"))
+	assert.Same(t, s.Country, s.ForEdition("dbip-country-lite"))
+	assert.Same(t, s.ASN, s.ForEdition("dbip-asn-lite"))
+	assert.Nil(t, s.ForEdition("GeoIP2-Anonymous-IP"))
+}
@@ -0,0 +1,324 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inspect
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Change is a network whose country or time zone differs between builds.
+type Change struct {
+	Network     *net.IPNet
+	OldCountry  string
+	NewCountry  string
+	OldTimeZone string
+	NewTimeZone string
+}
+
+func (c Change) String() string {
+	var moves []string
+	if c.OldCountry != c.NewCountry {
+		moves = append(moves, fmt.Sprintf("%s -> %s", orNone(c.OldCountry), orNone(c.NewCountry)))
+	}
+	if c.OldTimeZone != c.NewTimeZone {
+		moves = append(moves, fmt.Sprintf("%s -> %s", orNone(c.OldTimeZone), orNone(c.NewTimeZone)))
+	}
+	return c.Network.String() + " " + strings.Join(moves, ", ")
+}
+
+func orNone(s string) string {
+	if len(s) <= 0 {
+		return "none"
+	}
+	return s
+}
+
+// CountryChanges counts the networks a country lost to and gained from
+// others.
+type CountryChanges struct {
+	Lost   int
+	Gained int
+}
+
+// TimeZoneChange is a move of networks from one time zone to another.
+type TimeZoneChange struct {
+	Old string
+	New string
+}
+
+// DiffSummary sums up how networks moved between two builds. Only
+// networks known to both builds are compared, the others are merely
+// counted.
+type DiffSummary struct {
+	OldBuild uint
+	NewBuild uint
+	// Networks that moved to another country, and those that moved to
+	// another time zone.
+	CountryChanged  int
+	TimeZoneChanged int
+	// Networks only known to the new or the old build.
+	Added   int
+	Removed int
+	// By ISO code.
+	Countries map[string]*CountryChanges
+	// Networks per move.
+	TimeZones map[TimeZoneChange]int
+	// The biggest changed networks, biggest first.
+	Largest []Change
+}
+
+// How many of the largest changes a summary keeps.
+const largestChanges = 10
+
+// Just what is compared.
+type diffRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		TimeZone string `maxminddb:"time_zone"`
+	} `maxminddb:"location"`
+}
+
+// Walks the networks of a database in address order. IPv4 networks are
+// mapped into the IPv6 tree the way the database stores them (::a.b.c.d)
+// so both builds order the same.
+type walker struct {
+	networks *maxminddb.Networks
+	ok       bool
+	err      error
+	network  *net.IPNet
+	first    net.IP
+	last     net.IP
+	record   diffRecord
+	// Whether the current network overlapped with one of the other build.
+	matched bool
+}
+
+func (w *walker) next() {
+	w.ok = w.networks.Next()
+	w.matched = false
+	if !w.ok {
+		w.err = w.networks.Err()
+		return
+	}
+	w.record = diffRecord{}
+	w.network, w.err = w.networks.Network(&w.record)
+	if w.err != nil {
+		w.ok = false
+		return
+	}
+
+	ip, mask := w.network.IP, w.network.Mask
+	if len(ip) == net.IPv4len {
+		ones, _ := mask.Size()
+		ip = append(make(net.IP, net.IPv6len-net.IPv4len), ip...)
+		mask = net.CIDRMask(ones+96, 128)
+	}
+	w.first = ip.Mask(mask)
+	w.last = make(net.IP, len(w.first))
+	for i := range w.first {
+		w.last[i] = w.first[i] | ^mask[i]
+	}
+}
+
+func (w *walker) prefixLength() int {
+	ones, _ := w.network.Mask.Size()
+	return ones
+}
+
+// Diff compares the build at newPath with the one at oldPath.
+func Diff(oldPath string, newPath string) (*DiffSummary, error) {
+	oldDB, err := maxminddb.Open(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	defer oldDB.Close()
+	newDB, err := maxminddb.Open(newPath)
+	if err != nil {
+		return nil, err
+	}
+	defer newDB.Close()
+
+	summary := &DiffSummary{
+		OldBuild:  oldDB.Metadata.BuildEpoch,
+		NewBuild:  newDB.Metadata.BuildEpoch,
+		Countries: map[string]*CountryChanges{},
+		TimeZones: map[TimeZoneChange]int{},
+	}
+	old := &walker{networks: oldDB.Networks(maxminddb.SkipAliasedNetworks)}
+	new := &walker{networks: newDB.Networks(maxminddb.SkipAliasedNetworks)}
+	old.next()
+	new.next()
+	for old.ok && new.ok {
+		if bytes.Compare(old.last, new.first) < 0 {
+			if !old.matched {
+				summary.Removed++
+			}
+			old.next()
+			continue
+		}
+		if bytes.Compare(new.last, old.first) < 0 {
+			if !new.matched {
+				summary.Added++
+			}
+			new.next()
+			continue
+		}
+
+		// Overlapping prefixes are nested, the smaller one is what they
+		// have in common.
+		old.matched = true
+		new.matched = true
+		common := new.network
+		if old.prefixLength() > new.prefixLength() {
+			common = old.network
+		}
+		summary.add(Change{
+			Network:     common,
+			OldCountry:  old.record.Country.IsoCode,
+			NewCountry:  new.record.Country.IsoCode,
+			OldTimeZone: old.record.Location.TimeZone,
+			NewTimeZone: new.record.Location.TimeZone,
+		})
+
+		switch bytes.Compare(old.last, new.last) {
+		case -1:
+			old.next()
+		case 1:
+			new.next()
+		default:
+			old.next()
+			new.next()
+		}
+	}
+	for ; old.ok; old.next() {
+		if !old.matched {
+			summary.Removed++
+		}
+	}
+	for ; new.ok; new.next() {
+		if !new.matched {
+			summary.Added++
+		}
+	}
+	if old.err != nil {
+		return nil, old.err
+	}
+	if new.err != nil {
+		return nil, new.err
+	}
+	return summary, nil
+}
+
+func (s *DiffSummary) country(code string) *CountryChanges {
+	changes, ok := s.Countries[code]
+	if !ok {
+		changes = &CountryChanges{}
+		s.Countries[code] = changes
+	}
+	return changes
+}
+
+func (s *DiffSummary) add(c Change) {
+	if c.OldCountry == c.NewCountry && c.OldTimeZone == c.NewTimeZone {
+		return
+	}
+	if c.OldCountry != c.NewCountry {
+		s.CountryChanged++
+		s.country(orNone(c.OldCountry)).Lost++
+		s.country(orNone(c.NewCountry)).Gained++
+	}
+	if c.OldTimeZone != c.NewTimeZone {
+		s.TimeZoneChanged++
+		s.TimeZones[TimeZoneChange{c.OldTimeZone, c.NewTimeZone}]++
+	}
+
+	// Sorted by prefix length, earlier addresses first among equals.
+	i := sort.Search(len(s.Largest), func(i int) bool {
+		ones, _ := s.Largest[i].Network.Mask.Size()
+		cOnes, _ := c.Network.Mask.Size()
+		return ones > cOnes
+	})
+	if i >= largestChanges {
+		return
+	}
+	s.Largest = append(s.Largest, Change{})
+	copy(s.Largest[i+1:], s.Largest[i:])
+	s.Largest[i] = c
+	if len(s.Largest) > largestChanges {
+		s.Largest = s.Largest[:largestChanges]
+	}
+}
+
+// Changed is whether any network moved.
+func (s *DiffSummary) Changed() bool {
+	return s.CountryChanged > 0 || s.TimeZoneChanged > 0
+}
+
+func (s *DiffSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "build %d -> %d: %d networks changed country, %d changed time zone, %d added, %d removed\n",
+		s.OldBuild, s.NewBuild, s.CountryChanged, s.TimeZoneChanged, s.Added, s.Removed)
+
+	if len(s.Countries) > 0 {
+		b.WriteString("Countries (networks lost/gained):\n")
+		var codes []string
+		for code := range s.Countries {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			fmt.Fprintf(&b, "  %s -%d +%d\n", code, s.Countries[code].Lost, s.Countries[code].Gained)
+		}
+	}
+
+	if len(s.Largest) > 0 {
+		b.WriteString("Largest changed networks:\n")
+		for _, change := range s.Largest {
+			fmt.Fprintf(&b, "  %s\n", change)
+		}
+	}
+
+	if len(s.TimeZones) > 0 {
+		b.WriteString("Changed time zones (networks):\n")
+		var moves []TimeZoneChange
+		for move := range s.TimeZones {
+			moves = append(moves, move)
+		}
+		sort.Slice(moves, func(i, j int) bool {
+			if s.TimeZones[moves[i]] != s.TimeZones[moves[j]] {
+				return s.TimeZones[moves[i]] > s.TimeZones[moves[j]]
+			}
+			return moves[i].Old+moves[i].New < moves[j].Old+moves[j].New
+		})
+		for _, move := range moves {
+			fmt.Fprintf(&b, "  %s -> %s: %d\n", orNone(move.Old), orNone(move.New), s.TimeZones[move])
+		}
+	}
+	return b.String()
+}
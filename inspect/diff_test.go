@@ -0,0 +1,95 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inspect
+
+import (
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func location(country string, timeZone string) mmdbtype.Map {
+	return mmdbtype.Map{
+		"country":  mmdbtype.Map{"iso_code": mmdbtype.String(country)},
+		"location": mmdbtype.Map{"time_zone": mmdbtype.String(timeZone)},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := dbtest.File(t, dbtest.Options{BuildEpoch: 1, Records: dbtest.Records{
+		"192.0.2.0/24":    location("AT", "Europe/Vienna"),
+		"198.51.100.0/24": location("AT", "Europe/Vienna"),
+		"203.0.113.0/24":  location("DE", "Europe/Berlin"),
+		"2001:db8::/32":   location("DE", "Europe/Berlin"),
+	}})
+	new := dbtest.File(t, dbtest.Options{BuildEpoch: 2, Records: dbtest.Records{
+		// Half of it moved.
+		"192.0.2.0/25":   location("AT", "Europe/Vienna"),
+		"192.0.2.128/25": location("DE", "Europe/Berlin"),
+		// Same country, other time zone.
+		"203.0.113.0/24": location("DE", "Europe/Busingen"),
+		// Moved as a whole.
+		"2001:db8::/32": location("CH", "Europe/Zurich"),
+		// Only in the new build.
+		"198.18.0.0/15": location("AT", "Europe/Vienna"),
+	}})
+
+	summary, err := Diff(old, new)
+	assert.NoError(t, err)
+	assert.True(t, summary.Changed())
+	assert.Equal(t, uint(1), summary.OldBuild)
+	assert.Equal(t, uint(2), summary.NewBuild)
+	assert.Equal(t, 2, summary.CountryChanged)
+	assert.Equal(t, 3, summary.TimeZoneChanged)
+	assert.Equal(t, 1, summary.Added)   // 198.18.0.0/15
+	assert.Equal(t, 1, summary.Removed) // 198.51.100.0/24
+	assert.Equal(t, &CountryChanges{Lost: 1}, summary.Countries["AT"])
+	assert.Equal(t, &CountryChanges{Lost: 1, Gained: 1}, summary.Countries["DE"])
+	assert.Equal(t, &CountryChanges{Gained: 1}, summary.Countries["CH"])
+	assert.Equal(t, map[TimeZoneChange]int{
+		{"Europe/Vienna", "Europe/Berlin"}:   1,
+		{"Europe/Berlin", "Europe/Busingen"}: 1,
+		{"Europe/Berlin", "Europe/Zurich"}:   1,
+	}, summary.TimeZones)
+
+	var largest []string
+	for _, change := range summary.Largest {
+		largest = append(largest, change.String())
+	}
+	assert.Equal(t, []string{
+		"203.0.113.0/24 Europe/Berlin -> Europe/Busingen",
+		"192.0.2.128/25 AT -> DE, Europe/Vienna -> Europe/Berlin",
+		"2001:db8::/32 DE -> CH, Europe/Berlin -> Europe/Zurich",
+	}, largest)
+	assert.Contains(t, summary.String(), "build 1 -> 2: 2 networks changed country, 3 changed time zone")
+}
+
+func TestDiffSame(t *testing.T) {
+	path := writeMMDB(t)
+
+	summary, err := Diff(path, path)
+	assert.NoError(t, err)
+	assert.False(t, summary.Changed())
+	assert.Equal(t, 0, summary.Added)
+	assert.Equal(t, 0, summary.Removed)
+}
@@ -0,0 +1,151 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package inspect looks into mmdb files the way the service reads them.
+package inspect
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oschwald/geoip2-golang"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// Info writes the metadata of the database at path to w.
+func Info(path string, w io.Writer) error {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	meta := db.Metadata()
+
+	var descriptions []string
+	for lang, description := range meta.Description {
+		descriptions = append(descriptions, lang+": "+description)
+	}
+	sort.Strings(descriptions)
+
+	build := time.Unix(int64(meta.BuildEpoch), 0).UTC()
+	fmt.Fprintf(w, "Edition:        %s\n", meta.DatabaseType)
+	fmt.Fprintf(w, "Build:          %d (%s)\n", meta.BuildEpoch, build.Format(time.RFC3339))
+	fmt.Fprintf(w, "IP version:     %d\n", meta.IPVersion)
+	fmt.Fprintf(w, "Node count:     %d\n", meta.NodeCount)
+	fmt.Fprintf(w, "Record size:    %d\n", meta.RecordSize)
+	fmt.Fprintf(w, "Languages:      %s\n", strings.Join(meta.Languages, ", "))
+	fmt.Fprintf(w, "Format version: %d.%d\n", meta.BinaryFormatMajorVersion, meta.BinaryFormatMinorVersion)
+	fmt.Fprintf(w, "Description:    %s\n", strings.Join(descriptions, "; "))
+	return nil
+}
+
+// Verify fully checks the structure of the search tree and data section of
+// the database at path.
+func Verify(path string) error {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Verify()
+}
+
+// Network is an exported network with the location data the service
+// serves from it.
+type Network struct {
+	Network      string   `json:"network"`
+	Country      string   `json:"country,omitempty"`
+	Subdivisions []string `json:"subdivisions,omitempty"`
+	City         string   `json:"city,omitempty"`
+	TimeZone     string   `json:"time_zone,omitempty"`
+}
+
+// Formats supported by Export.
+const (
+	CSV       = "csv"
+	JSONLines = "jsonl"
+)
+
+// The language cities are exported in.
+const cityLocale = "en"
+
+// Export writes every network of the database at path to w in format (CSV
+// with a header line or JSON lines). Cities are named in English,
+// subdivisions by their ISO codes, separated by / in CSV.
+func Export(path string, format string, w io.Writer) error {
+	var write func(Network) error
+	var flush func() error
+	switch format {
+	case CSV:
+		c := csv.NewWriter(w)
+		write = func(n Network) error {
+			return c.Write([]string{n.Network, n.Country, strings.Join(n.Subdivisions, "/"), n.City, n.TimeZone})
+		}
+		flush = func() error {
+			c.Flush()
+			return c.Error()
+		}
+		if err := c.Write([]string{"network", "country", "subdivisions", "city", "time_zone"}); err != nil {
+			return err
+		}
+	case JSONLines:
+		encoder := json.NewEncoder(w)
+		write = func(n Network) error { return encoder.Encode(n) }
+		flush = func() error { return nil }
+	default:
+		return fmt.Errorf("unknown export format %q (%s and %s are supported)", format, CSV, JSONLines)
+	}
+
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	networks := db.Networks(maxminddb.SkipAliasedNetworks)
+	for networks.Next() {
+		var record geoip2.City
+		network, err := networks.Network(&record)
+		if err != nil {
+			return err
+		}
+		n := Network{
+			Network:  network.String(),
+			Country:  record.Country.IsoCode,
+			City:     record.City.Names[cityLocale],
+			TimeZone: record.Location.TimeZone,
+		}
+		for _, subdivision := range record.Subdivisions {
+			n.Subdivisions = append(n.Subdivisions, subdivision.IsoCode)
+		}
+		if err := write(n); err != nil {
+			return err
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
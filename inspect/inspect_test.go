@@ -0,0 +1,92 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package inspect
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeMMDB(t *testing.T) string {
+	return dbtest.File(t, dbtest.Options{Records: dbtest.Records{
+		"192.0.2.0/24": {
+			"country":      mmdbtype.Map{"iso_code": mmdbtype.String("AT")},
+			"subdivisions": mmdbtype.Slice{mmdbtype.Map{"iso_code": mmdbtype.String("9")}},
+			"city":         mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Vienna")}},
+			"location":     mmdbtype.Map{"time_zone": mmdbtype.String("Europe/Vienna")},
+		},
+		"2001:db8::/32": {
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+		},
+	}})
+}
+
+func TestInfo(t *testing.T) {
+	path := writeMMDB(t)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Info(path, &buf))
+	assert.Contains(t, buf.String(), "Edition:        GeoLite2-City\n")
+	assert.Contains(t, buf.String(), "Build:          1523952000 (2018-04-17T08:00:00Z)\n")
+	assert.Contains(t, buf.String(), "IP version:     6\n")
+	assert.Contains(t, buf.String(), "Languages:      en\n")
+	assert.Contains(t, buf.String(), "Description:    en: dbtest GeoLite2-City\n")
+}
+
+func TestVerify(t *testing.T) {
+	path := writeMMDB(t)
+	assert.NoError(t, Verify(path))
+
+	// Break the data section, which the metadata still happily points at.
+	data, _ := ioutil.ReadFile(path)
+	marker := bytes.LastIndex(data, []byte("\xab\xcd\xefMaxMind.com"))
+	for i := marker - 16 - 64; i < marker-16; i++ {
+		data[i] = 0xff
+	}
+	broken := filepath.Join(filepath.Dir(path), filepath.Base(path)+"-broken")
+	ioutil.WriteFile(broken, data, 0644)
+	defer os.Remove(broken)
+	assert.Error(t, Verify(broken))
+}
+
+func TestExport(t *testing.T) {
+	path := writeMMDB(t)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Export(path, CSV, &buf))
+	assert.Equal(t, "network,country,subdivisions,city,time_zone\n"+
+		"192.0.2.0/24,AT,9,Vienna,Europe/Vienna\n"+
+		"2001:db8::/32,DE,,,\n", buf.String())
+
+	buf.Reset()
+	assert.NoError(t, Export(path, JSONLines, &buf))
+	assert.Equal(t, `{"network":"192.0.2.0/24","country":"AT","subdivisions":["9"],"city":"Vienna","time_zone":"Europe/Vienna"}`+"\n"+
+		`{"network":"2001:db8::/32","country":"DE"}`+"\n", buf.String())
+
+	assert.Error(t, Export(path, "xml", &buf))
+}
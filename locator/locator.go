@@ -0,0 +1,151 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package locator looks up where IPs are. The API resources only talk to a
+// Locator, what answers behind it (a database, overrides, a cache...) is up
+// to whoever sets them up.
+package locator
+
+import (
+	"net"
+
+	"github.com/apachelogger/geoip-kde-org/database"
+)
+
+// ErrUnavailable is returned while a Locator has nothing to look things up
+// in yet (e.g. on first start while the download is still going).
+var ErrUnavailable = database.ErrUnavailable
+
+// Location is what is known about where an IP is. Everything unknown is
+// left empty.
+type Location struct {
+	CountryCode string
+	CountryName string
+	RegionCode  string
+	RegionName  string
+	City        string
+	PostalCode  string
+	Latitude    float64
+	Longitude   float64
+	MetroCode   uint
+	TimeZone    string
+	// From the ASN edition, if loaded.
+	ASN            uint
+	ASOrganization string
+}
+
+// Locator finds the Location of IPs. IPs it knows nothing about have an
+// empty Location, errors are reserved for failing lookups.
+type Locator interface {
+	Locate(ip net.IP) (Location, error)
+}
+
+// Func adapts a func to a Locator.
+type Func func(ip net.IP) (Location, error)
+
+// Locate calls f.
+func (f Func) Locate(ip net.IP) (Location, error) {
+	return f(ip)
+}
+
+type databaseLocator struct {
+	dbs *database.Set
+}
+
+// New returns a Locator looking IPs up in the City database of dbs. The
+// Country database fills in the country where City knows none, the ASN one
+// the network. The databases may be swapped out or still be empty, each
+// lookup uses whatever is loaded at the time. Only City is mandatory.
+func New(dbs *database.Set) Locator {
+	return &databaseLocator{dbs}
+}
+
+func (l *databaseLocator) Locate(ip net.IP) (Location, error) {
+	reader, release, err := l.dbs.City.Acquire()
+	if err != nil {
+		return Location{}, err
+	}
+	defer release()
+
+	record, err := reader.City(ip)
+	if err != nil {
+		return Location{}, err
+	}
+	location := Location{
+		CountryCode: record.Country.IsoCode,
+		CountryName: record.Country.Names["en"],
+		City:        record.City.Names["en"],
+		PostalCode:  record.Postal.Code,
+		Latitude:    record.Location.Latitude,
+		Longitude:   record.Location.Longitude,
+		MetroCode:   record.Location.MetroCode,
+		TimeZone:    record.Location.TimeZone,
+	}
+	if len(record.Subdivisions) >= 1 {
+		location.RegionCode = record.Subdivisions[0].IsoCode
+		location.RegionName = record.Subdivisions[0].Names["en"]
+	}
+	if err := l.locateCountry(ip, &location); err != nil {
+		return Location{}, err
+	}
+	if err := l.locateASN(ip, &location); err != nil {
+		return Location{}, err
+	}
+	return location, nil
+}
+
+func (l *databaseLocator) locateCountry(ip net.IP, location *Location) error {
+	if len(location.CountryCode) > 0 {
+		return nil
+	}
+	reader, release, err := l.dbs.Country.Acquire()
+	if err == ErrUnavailable {
+		return nil // optional
+	} else if err != nil {
+		return err
+	}
+	defer release()
+
+	record, err := reader.Country(ip)
+	if err != nil {
+		return err
+	}
+	location.CountryCode = record.Country.IsoCode
+	location.CountryName = record.Country.Names["en"]
+	return nil
+}
+
+func (l *databaseLocator) locateASN(ip net.IP, location *Location) error {
+	reader, release, err := l.dbs.ASN.Acquire()
+	if err == ErrUnavailable {
+		return nil // optional
+	} else if err != nil {
+		return err
+	}
+	defer release()
+
+	record, err := reader.ASN(ip)
+	if err != nil {
+		return err
+	}
+	location.ASN = record.AutonomousSystemNumber
+	location.ASOrganization = record.AutonomousSystemOrganization
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package locator
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocate(t *testing.T) {
+	dbs := database.NewSet()
+	dbtest.Load(t, dbs.City)
+	l := New(dbs)
+
+	location, err := l.Locate(net.ParseIP(dbtest.Vienna))
+	assert.NoError(t, err)
+	assert.Equal(t, Location{
+		CountryCode: "AT",
+		CountryName: "Austria",
+		RegionCode:  "9",
+		RegionName:  "Vienna",
+		City:        "Vienna",
+		PostalCode:  "1010",
+		Latitude:    48.2064,
+		Longitude:   16.3707,
+		TimeZone:    "Europe/Vienna",
+	}, location)
+
+	location, err = l.Locate(net.ParseIP(dbtest.NoSubdivisions))
+	assert.NoError(t, err)
+	assert.Empty(t, location.RegionCode)
+	assert.Empty(t, location.RegionName)
+	assert.Equal(t, "Reykjavik", location.City)
+
+	location, err = l.Locate(net.ParseIP(dbtest.Unknown))
+	assert.NoError(t, err)
+	assert.Equal(t, Location{}, location)
+}
+
+func TestLocateUnavailable(t *testing.T) {
+	_, err := New(database.NewSet()).Locate(net.ParseIP(dbtest.Vienna))
+	assert.Equal(t, ErrUnavailable, err)
+}
+
+func TestLocateCountryAndASN(t *testing.T) {
+	dbs := database.NewSet()
+	dbtest.Load(t, dbs.City)
+	load := func(db *database.Database, o dbtest.Options) {
+		if err := db.Load(dbtest.File(t, o)); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+	}
+	load(dbs.Country, dbtest.Options{
+		DatabaseType: "GeoLite2-Country",
+		Records: dbtest.Records{
+			"192.0.2.0/24": {"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE")}},
+			"172.16.0.0/12": {"country": mmdbtype.Map{
+				"iso_code": mmdbtype.String("DE"),
+				"names":    mmdbtype.Map{"en": mmdbtype.String("Germany")},
+			}},
+		},
+	})
+	load(dbs.ASN, dbtest.Options{
+		DatabaseType: "GeoLite2-ASN",
+		Records: dbtest.Records{
+			"192.0.2.0/24": {
+				"autonomous_system_number":       mmdbtype.Uint32(64496),
+				"autonomous_system_organization": mmdbtype.String("Example"),
+			},
+		},
+	})
+	l := New(dbs)
+
+	location, err := l.Locate(net.ParseIP(dbtest.Vienna))
+	assert.NoError(t, err)
+	assert.Equal(t, "AT", location.CountryCode, "City knows better")
+	assert.Equal(t, uint(64496), location.ASN)
+	assert.Equal(t, "Example", location.ASOrganization)
+
+	location, err = l.Locate(net.ParseIP(dbtest.Unknown))
+	assert.NoError(t, err)
+	assert.Equal(t, Location{CountryCode: "DE", CountryName: "Germany"}, location)
+}
+
+func TestFunc(t *testing.T) {
+	failure := errors.New("failure")
+	var l Locator = Func(func(ip net.IP) (Location, error) {
+		return Location{TimeZone: ip.String()}, failure
+	})
+	location, err := l.Locate(net.ParseIP("192.0.2.1"))
+	assert.Equal(t, failure, err)
+	assert.Equal(t, "192.0.2.1", location.TimeZone)
+}
@@ -21,199 +21,145 @@
 package main
 
 import (
-	"archive/tar"
-	"bufio"
-	"compress/gzip"
 	"context"
 	"flag"
-	"io"
-	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/apachelogger/geoip-kde-org/apis"
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/server"
 	"github.com/coreos/go-systemd/activation"
 	"github.com/gin-gonic/gin"
-	"github.com/oschwald/geoip2-golang"
 )
 
-var db *geoip2.Reader
+var dbs = database.NewSet()
 
-func downloadGeoLite2City() {
-	// FIXME: we should symlink the current version to the fixed name, but
-	//   store the actual files with a timestamp embedded. that way re-opening
-	//   the fixed path loads always the latest file
-	path := "GeoLite2-City.mmdb"
-	name := path
+var listen = flag.String("listen", os.Getenv("HOST")+":"+envOr("PORT", "8080"),
+	"`address` to serve on unless systemd passes sockets")
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", "https://geolite.maxmind.com/download/geoip/database/GeoLite2-City.tar.gz", nil)
-	if err != nil {
-		panic(err)
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		panic(err)
-	}
-	defer resp.Body.Close()
+var shutdownTimeout = flag.Duration("shutdown-timeout", 4*time.Second,
+	"how long to wait for requests in flight to finish when shutting down")
 
-	// Write the body to file.
-	// Reading from Body.Resp via Gzip and Bufio is substantially slower
-	// than first downloading the entire body and reading from local. I am
-	// not entirely sure why that is since bufio should make it fast :(
-	tmpfile, err := ioutil.TempFile("", "geoip-geolite2-city")
-	if err != nil {
-		panic(err)
-	}
-	defer os.Remove(tmpfile.Name()) // clean up
-	defer tmpfile.Close()
-	_, err = io.Copy(tmpfile, resp.Body)
-	if err != nil {
-		panic(err)
-	}
-	tmpfile.Seek(0, 0)
+var adminListen = flag.String("admin-listen", "",
+	"`address` to serve administrative calls (e.g. POST /reload) on, keep this local unless -replication-token protects it")
 
-	gzip, err := gzip.NewReader(bufio.NewReader(tmpfile))
-	if err != nil {
-		panic(err)
-	}
-	defer gzip.Close()
+var routePrefix = flag.String("route-prefix", os.Getenv("GEOIP_ROUTE_PREFIX"),
+	"`path` to serve all routes below (e.g. /geoip)")
 
-	tarReader := tar.NewReader(gzip)
+var trustedProxies = flag.String("trusted-proxies", os.Getenv("GEOIP_TRUSTED_PROXIES"),
+	"comma separated `list` of proxy IPs and CIDRs whose X-Forwarded-For to believe (default: all)")
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			panic(err)
-		}
+func main() {
+	flag.Usage = usage
+	flag.Parse()
 
-		info := header.FileInfo()
-		if info.Name() != name {
-			continue
-		}
+	if err := configure(); err != nil {
+		log.Fatalf("Invalid configuration: %s", err)
+	}
 
-		file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
-		if err != nil {
-			panic(err)
-		}
-		defer file.Close()
-		_, err = io.Copy(file, tarReader)
-		if err != nil {
-			panic(err)
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
 		}
-
-		break
+		return
 	}
-}
 
-func downloadGeoLite2() bool {
-	download := true
-	if stat, err := os.Stat("GeoLite2-City.mmdb"); err == nil {
-		if time.Since(stat.ModTime()).Hours() < 24*8 {
-			download = false
+	// Serve whatever we have, however stale, and update in the background.
+	// Without any database at all requests are answered with 503 until
+	// the download arrives.
+	loadMu.Lock()
+	for _, edition := range editions {
+		if err := load(edition); err != nil {
+			log.Printf("No %s to serve yet: %s", edition, err)
 		}
 	}
-
-	if download {
-		downloadGeoLite2City()
+	loadMu.Unlock()
+	defer dbs.Close()
+
+	opts := []server.Option{
+		server.WithDatabases(dbs),
+		server.WithMeta(meta),
+		server.WithAttribution(attributionNotice),
+		server.WithPrefix(*routePrefix),
+		server.WithUpdater(updateLoop),
+		server.WithShutdownTimeout(*shutdownTimeout),
 	}
-
-	return download
-}
-
-func main() {
-	flag.Parse()
-
-	downloadGeoLite2()
-
-	var err error
-	db, err = geoip2.Open("GeoLite2-City.mmdb")
-	if err != nil {
-		panic(err)
+	if len(*trustedProxies) > 0 {
+		opts = append(opts, server.WithTrustedProxies(strings.Split(*trustedProxies, ",")...))
 	}
-	defer db.Close()
-
-	log.Println("Ready to rumble...")
-	router := gin.Default()
-
-	rg := router.Group("/")
-	{
-		apis.ServeCalamaresResource(rg, db)
-		apis.ServeUbiquityResource(rg, db)
-		apis.ServeDebugResource(rg, db)
+	if *watch {
+		opts = append(opts, server.WithUpdater(watchDatabases))
+	}
+	if len(*adminListen) > 0 {
+		listener, err := net.Listen("tcp", *adminListen)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, server.WithAdmin(listener, adminHandler()))
+	}
+	router, err := server.New(opts...)
+	if err != nil {
+		log.Fatal(err)
 	}
-	router.GET("/", func(c *gin.Context) {
-		c.Redirect(http.StatusMovedPermanently, "/doc")
-	})
-	router.StaticFS("/doc", http.Dir("doc"))
-	router.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "OK") })
 
 	listeners, err := activation.Listeners(true)
 	if err != nil {
 		panic(err)
 	}
-
-	log.Println("starting servers")
-	var servers []*http.Server
-	for _, listener := range listeners {
-		server := &http.Server{Handler: router}
-		go server.Serve(listener)
-		servers = append(servers, server)
-	}
-
-	if len(servers) == 0 {
-		log.Println("servers empty. adding manual server")
-
-		host := os.Getenv("HOST")
-		port := os.Getenv("PORT")
-		if len(port) <= 0 {
-			port = "8080"
-		}
-
-		server := &http.Server{
-			Addr:    host + ":" + port,
-			Handler: router,
+	if len(listeners) == 0 {
+		log.Println("no sockets from systemd. listening on " + *listen)
+		listener, err := net.Listen("tcp", *listen)
+		if err != nil {
+			log.Fatal(err)
 		}
-		go server.ListenAndServe()
-		servers = append(servers, server)
+		listeners = append(listeners, listener)
 	}
 
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-
-	quitTicker := time.NewTicker(14 * 24 * time.Hour)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		<-quitTicker.C
-		quitTicker.Stop()
-		close(quit)
-		log.Println("Auto-terminating after 14 days to force a db update.")
+		for range hup {
+			log.Println("Reloading database")
+			if err := reload(); err != nil {
+				log.Printf("Reload failed, keeping current database: %s", err)
+			}
+		}
 	}()
 
-	// Wait for some quit cause.
-	// This could be INT, TERM, QUIT or the db update trigger.
+	// Run until some quit cause.
+	// This could be INT, TERM or QUIT.
 	// We'll then do a zero downtime shutdown.
 	// This relies on systemd managing the socket and us doing graceful listener
 	// shutdown. Once we are no longer listening, the system starts backlogging
 	// the socket until we get restarted and listen again.
 	// Ideally this results in zero dropped connections.
-	<-quit
-	log.Println("servers are shutting down")
-	quitTicker.Stop()
-
-	for _, srv := range servers {
-		ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
-		defer cancel()
-		srv.SetKeepAlivesEnabled(false)
-		if err := srv.Shutdown(ctx); err != nil {
-			log.Fatalf("Server Shutdown: %s", err)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+	log.Println("Ready to rumble...")
+	if err := router.Run(ctx, listeners...); err != nil {
+		log.Fatalf("Server: %s", err)
 	}
-
 	log.Println("Server exiting")
 }
+
+// The administrative routes, protected by the replication token when
+// followers need to reach them.
+func adminHandler() http.Handler {
+	admin := gin.Default()
+	group := admin.Group("/")
+	if replicating() {
+		// Followers need to reach the admin listener, so it can't be
+		// trusted to be local anymore.
+		group.Use(apis.RequireToken(*replicationToken))
+		apis.ServeReplicaResource(group, replica)
+	}
+	apis.ServeAdminResource(group, reload)
+	return admin
+}
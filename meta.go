@@ -0,0 +1,144 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/apachelogger/geoip-kde-org/updater"
+)
+
+// Set at build time with -ldflags "-X main.version=<version>", otherwise
+// the module version is used if there is one.
+var version = ""
+
+func binaryVersion() string {
+	if len(version) > 0 {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && len(info.Main.Version) > 0 {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// When the next update check is due, zero while one is running.
+var nextUpdate struct {
+	sync.Mutex
+	at time.Time
+}
+
+func scheduleUpdate(at time.Time) {
+	nextUpdate.Lock()
+	defer nextUpdate.Unlock()
+	nextUpdate.at = at
+}
+
+// Describes what is served right now.
+func meta() models.Meta {
+	m := models.Meta{
+		Version:  binaryVersion(),
+		Provider: *providerName,
+		Editions: []models.EditionMeta{},
+	}
+
+	nextUpdate.Lock()
+	if !nextUpdate.at.IsZero() {
+		at := nextUpdate.at.UTC()
+		m.NextUpdate = &at
+	}
+	nextUpdate.Unlock()
+
+	if snapshot, ok := servedSnapshot.Load().([]models.EditionMeta); ok {
+		m.Editions = snapshot
+	}
+	return m
+}
+
+// What got loaded per edition. Guarded by loadMu.
+var served = map[string]models.EditionMeta{}
+
+// All of them in edition order, read by every meta request so that those
+// never wait for loads (and their canaries) to finish.
+var servedSnapshot atomic.Value
+
+// Records what got loaded for edition. Needs loadMu held.
+func updateServed(edition string, info os.FileInfo) {
+	reader, release, err := dbs.ForEdition(edition).Acquire()
+	if err != nil {
+		return
+	}
+	served[edition] = models.EditionMeta{
+		Edition:    edition,
+		BuildEpoch: reader.Metadata().BuildEpoch,
+		// The files are written when installed.
+		Downloaded: info.ModTime().UTC(),
+	}
+	release()
+
+	// A new slice every time, readers keep using theirs.
+	snapshot := []models.EditionMeta{}
+	for _, edition := range editions {
+		if m, ok := served[edition]; ok {
+			snapshot = append(snapshot, m)
+		}
+	}
+	servedSnapshot.Store(snapshot)
+}
+
+// The licence notices of the loaded databases by edition. Guarded by loadMu.
+var notices = map[string]string{}
+
+// All of them combined, read by every request.
+var attribution atomic.Value
+
+// Records the notice of the just loaded database of edition. Needs loadMu
+// held.
+func updateAttribution(edition string) {
+	reader, release, err := dbs.ForEdition(edition).Acquire()
+	if err != nil {
+		return
+	}
+	notices[edition] = updater.Attribution(reader.Metadata().DatabaseType)
+	release()
+
+	var texts []string
+	seen := map[string]bool{}
+	for _, edition := range editions {
+		if text := notices[edition]; len(text) > 0 && !seen[text] {
+			texts = append(texts, text)
+			seen[text] = true
+		}
+	}
+	attribution.Store(strings.Join(texts, " "))
+}
+
+// The notices the licences of the served databases require.
+func attributionNotice() string {
+	notice, _ := attribution.Load().(string)
+	return notice
+}
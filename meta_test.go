@@ -0,0 +1,57 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetaDoesNotWaitForLoads(t *testing.T) {
+	const edition = "GeoLite2-City"
+	stubUpstream(t)
+	t.Cleanup(func() { dbs.City.Close() })
+	_, err := store.Install(edition, func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{})
+	})
+	assert.NoError(t, err)
+
+	loadMu.Lock()
+	assert.NoError(t, load(edition))
+	// Still held, as during a slow reload.
+	done := make(chan models.Meta)
+	go func() { done <- meta() }()
+	select {
+	case m := <-done:
+		if assert.Len(t, m.Editions, 1) {
+			assert.Equal(t, edition, m.Editions[0].Edition)
+			assert.Equal(t, uint(dbtest.BuildEpoch), m.Editions[0].BuildEpoch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("meta waited for loadMu")
+	}
+	loadMu.Unlock()
+}
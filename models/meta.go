@@ -0,0 +1,42 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package models
+
+import "time"
+
+// Meta describes what the service serves.
+type Meta struct {
+	// Version of the service binary.
+	Version  string        `json:"version"`
+	Provider string        `json:"provider"`
+	Editions []EditionMeta `json:"editions"`
+	// NextUpdate is when the next update check is due, unset while one is
+	// running.
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+}
+
+// EditionMeta describes the loaded build of a database edition.
+type EditionMeta struct {
+	Edition    string `json:"edition"`
+	BuildEpoch uint   `json:"build_epoch"`
+	// Downloaded is when the build was installed on this host.
+	Downloaded time.Time `json:"downloaded"`
+}
@@ -0,0 +1,38 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package models
+
+// Replica describes the database file of an edition as a leader serves it
+// to its followers. The body is the mmdb, the version and checksum are sent
+// as ReplicaVersionHeader and ReplicaChecksumHeader. The ETag is the quoted
+// checksum too so followers can resume and ask for newer builds only.
+type Replica struct {
+	Path string
+	// Version is the build epoch.
+	Version uint
+	// SHA256 of the file in hex.
+	SHA256 string
+}
+
+const (
+	ReplicaVersionHeader  = "X-Geoip-Version"
+	ReplicaChecksumHeader = "X-Geoip-Sha256"
+)
@@ -23,7 +23,7 @@ package models
 import (
 	"encoding/xml"
 
-	geoip2 "github.com/oschwald/geoip2-golang"
+	"github.com/apachelogger/geoip-kde-org/locator"
 )
 
 // UbiquityGeoIP is the data model for ubiquity-style output (compatible with geoip.ubuntu.com)
@@ -44,24 +44,27 @@ type UbiquityGeoIP struct {
 	TimeZone      string
 }
 
-// NewUbiquityGeoIPFromGeoIP2Record creates a new ubiquity data entity from a geoip2 record
-func NewUbiquityGeoIPFromGeoIP2Record(ip string, record *geoip2.City) UbiquityGeoIP {
-	obj := UbiquityGeoIP{
+// NewUbiquityGeoIPFromLocation creates a new ubiquity data entity from a location
+func NewUbiquityGeoIPFromLocation(ip string, location locator.Location) UbiquityGeoIP {
+	return UbiquityGeoIP{
 		IP:            ip,
 		Status:        "OK",
-		CountryCode:   record.Country.IsoCode,
+		CountryCode:   location.CountryCode,
 		CountryCode3:  "", // CountryCode3 is not part of
-		CountryName:   record.Country.Names["en"],
-		City:          record.City.Names["en"],
-		ZipPostalCode: record.Postal.Code,
-		Latitude:      record.Location.Latitude,
-		Longitude:     record.Location.Longitude,
-		AreaCode:      record.Location.MetroCode,
-		TimeZone:      record.Location.TimeZone,
+		CountryName:   location.CountryName,
+		RegionCode:    location.RegionCode,
+		RegionName:    location.RegionName,
+		City:          location.City,
+		ZipPostalCode: location.PostalCode,
+		Latitude:      location.Latitude,
+		Longitude:     location.Longitude,
+		AreaCode:      location.MetroCode,
+		TimeZone:      location.TimeZone,
 	}
-	if len(record.Subdivisions) >= 1 {
-		obj.RegionCode = record.Subdivisions[0].IsoCode
-		obj.RegionName = record.Subdivisions[0].Names["en"]
-	}
-	return obj
+}
+
+// NewUbiquityGeoIPError creates a ubiquity data entity for a failed lookup.
+// Only the IP is set, Status is ERROR.
+func NewUbiquityGeoIPError(ip string) UbiquityGeoIP {
+	return UbiquityGeoIP{IP: ip, Status: "ERROR"}
 }
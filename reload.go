@@ -0,0 +1,190 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var watch = flag.Bool("watch", false,
+	"reload databases when their files change (e.g. when replaced by hand)")
+
+// Serializes everything that changes which databases are served.
+var loadMu sync.Mutex
+
+// What got loaded last per edition, to tell whether the file actually
+// changed.
+var loaded = map[string]os.FileInfo{}
+
+// Opens the served databases from disk and switches over to them. They need
+// to pass the canaries, on failure the current database is kept.
+func reload() error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	var failures []error
+	for _, edition := range editions {
+		if err := checkAndLoad(edition); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return joinErrors(failures)
+}
+
+// reload, but only the databases that changed on disk.
+func reloadIfChanged() error {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	var failures []error
+	for _, edition := range editions {
+		if !changedOnDisk(edition) {
+			continue
+		}
+		if err := checkAndLoad(edition); err != nil {
+			failures = append(failures, err)
+		}
+	}
+	return joinErrors(failures)
+}
+
+// Needs loadMu held.
+func checkAndLoad(edition string) error {
+	adopted, err := adopt(edition)
+	if err != nil {
+		return err
+	}
+	// Adopted builds went through validation already.
+	if !adopted {
+		if err := checkEdition(edition, store.Path(edition)); err != nil {
+			return err
+		}
+		if err := checkCanaries(edition, store.Path(edition)); err != nil {
+			return err
+		}
+	}
+	return load(edition)
+}
+
+// Moves a database put in place of the stable path of edition by hand into
+// the versioned store, so rollbacks, pruning and retention keep working.
+// It gets validated like any download. Needs loadMu held.
+func adopt(edition string) (bool, error) {
+	unlock, err := store.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	path, err := store.Adopt(edition)
+	if err != nil {
+		return false, fmt.Errorf("failed to adopt %s: %s", store.Path(edition), err)
+	}
+	if len(path) <= 0 {
+		return false, nil
+	}
+	log.Printf("Adopted %s as %s", store.Path(edition), path)
+	if err := store.Prune(edition, *keep); err != nil {
+		log.Printf("Failed to prune old builds: %s", err)
+	}
+	return true, nil
+}
+
+// Switches over to the database of edition on disk without further checks.
+// Needs loadMu held.
+func load(edition string) error {
+	path := store.Path(edition)
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := dbs.ForEdition(edition).Load(path); err != nil {
+		return err
+	}
+	loaded[edition] = info
+	log.Printf("Now serving %s", path)
+	updateServed(edition, info)
+	updateAttribution(edition)
+	if err := recordReplica(edition, path); err != nil {
+		log.Printf("Cannot serve %s to followers: %s", edition, err)
+	}
+	return nil
+}
+
+// Whether the database of edition on disk is something other than what we
+// loaded last. Needs loadMu held.
+func changedOnDisk(edition string) bool {
+	info, err := os.Stat(store.Path(edition))
+	if err != nil {
+		return false // nothing to load anyway
+	}
+	last, ok := loaded[edition]
+	return !ok || !os.SameFile(last, info) || !last.ModTime().Equal(info.ModTime())
+}
+
+// Reloads whenever a served database gets replaced until stop is closed.
+// The directory is watched rather than the files as the stable paths are
+// symlinks that get renamed over.
+func watchDatabases(stop <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("Cannot watch databases: %s", err)
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(store.Dir); err != nil {
+		log.Printf("Cannot watch databases: %s", err)
+		return
+	}
+
+	paths := map[string]bool{}
+	for _, edition := range editions {
+		paths[filepath.Clean(store.Path(edition))] = true
+	}
+	// Copying a file in produces a burst of events, only act once it has
+	// been quiet for a moment.
+	settle := time.NewTimer(time.Hour)
+	settle.Stop()
+	for {
+		select {
+		case event := <-watcher.Events:
+			if paths[filepath.Clean(event.Name)] && event.Op&(fsnotify.Create|fsnotify.Write|fsnotify.Rename) != 0 {
+				settle.Reset(time.Second)
+			}
+		case err := <-watcher.Errors:
+			log.Printf("Error watching databases: %s", err)
+		case <-settle.C:
+			// Our own updates show up here too, they are loaded already.
+			if err := reloadIfChanged(); err != nil {
+				log.Printf("Failed to reload changed database: %s", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
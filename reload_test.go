@@ -0,0 +1,74 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAndLoadAdopts(t *testing.T) {
+	const edition = "GeoLite2-City"
+	stubUpstream(t)
+	t.Cleanup(func() { dbs.City.Close() })
+
+	// Replaced by hand with mv.
+	moved := filepath.Join(store.Dir, "moved.mmdb")
+	dbtest.WriteFile(t, moved, dbtest.Options{})
+	assert.NoError(t, os.Rename(moved, store.Path(edition)))
+
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	assert.NoError(t, checkAndLoad(edition))
+	current, err := store.Current(edition)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(dbtest.BuildEpoch), current)
+	assert.True(t, dbs.City.Loaded())
+}
+
+func TestCheckAndLoadRejectsOtherEditions(t *testing.T) {
+	const edition = "GeoLite2-City"
+	stubUpstream(t)
+	t.Cleanup(func() { dbs.City.Close() })
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	// Moved in by hand, gets adopted through validation.
+	moved := filepath.Join(store.Dir, "moved.mmdb")
+	dbtest.WriteFile(t, moved, dbtest.Options{DatabaseType: "GeoLite2-ASN"})
+	assert.NoError(t, os.Rename(moved, store.Path(edition)))
+	assert.Error(t, checkAndLoad(edition))
+	assert.False(t, dbs.City.Loaded())
+
+	// Written over the served build.
+	_, err := store.Install(edition, func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{})
+	})
+	assert.NoError(t, err)
+	dbtest.WriteFile(t, store.Path(edition), dbtest.Options{DatabaseType: "GeoLite2-Country"})
+	assert.Error(t, checkAndLoad(edition))
+	assert.False(t, dbs.City.Loaded())
+}
@@ -0,0 +1,100 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/apachelogger/geoip-kde-org/updater"
+)
+
+var replicationToken = flag.String("replication-token", os.Getenv("GEOIP_REPLICATION_TOKEN"),
+	"`secret` followers need to present to fetch databases from the admin listener, and that -provider leader presents")
+
+var leader = &updater.Leader{}
+
+func init() {
+	flag.StringVar(&leader.URL, "leader-url", os.Getenv("GEOIP_LEADER_URL"),
+		"`URL` of the admin listener of the instance -provider leader follows")
+}
+
+// What followers get served per edition. Guarded by loadMu.
+var replicas = map[string]models.Replica{}
+
+// Whether we serve followers at all.
+func replicating() bool {
+	return len(*replicationToken) > 0 && len(*adminListen) > 0
+}
+
+// Records the database of edition at path that just got loaded so it is
+// what followers get. Needs loadMu held.
+func recordReplica(edition string, path string) error {
+	delete(replicas, edition)
+	if !replicating() {
+		return nil
+	}
+
+	// The stable path is a symlink that moves on with the next update.
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+
+	reader, release, err := dbs.ForEdition(edition).Acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	replicas[edition] = models.Replica{
+		Path:    path,
+		Version: reader.Metadata().BuildEpoch,
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+	}
+	return nil
+}
+
+// The database of edition followers get.
+func replica(edition string) (models.Replica, error) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	r, ok := replicas[edition]
+	if !ok {
+		return models.Replica{}, fmt.Errorf("%s is not served", edition)
+	}
+	return r, nil
+}
@@ -0,0 +1,289 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package server puts the GeoIP endpoints together into an http.Handler
+// so they can be served on their own or mounted into other services.
+//
+//	dbs := database.NewSet()
+//	dbs.City.Load("GeoLite2-City.mmdb")
+//	s, err := server.New(server.WithDatabases(dbs), server.WithPrefix("/geoip"))
+//	mux.Handle("/geoip/", s)
+//
+// Or run on its own, keeping the databases up to date alongside:
+//
+//	s, err := server.New(server.WithDatabases(dbs), server.WithUpdater(update))
+//	err = s.Run(ctx, listener)
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/apachelogger/geoip-kde-org/apis"
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/locator"
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Endpoint names a group of routes that can be enabled.
+type Endpoint string
+
+// The endpoints (all relative to the prefix).
+const (
+	// Calamares serves GET /v1/calamares.
+	Calamares Endpoint = "calamares"
+	// Ubiquity serves GET /v1/ubiquity.
+	Ubiquity Endpoint = "ubiquity"
+	// Debug serves GET /debug.
+	Debug Endpoint = "debug"
+	// Meta serves GET /v1/meta, only if there is a WithMeta.
+	Meta Endpoint = "meta"
+	// Doc serves the API documentation on /doc and redirects / there.
+	Doc Endpoint = "doc"
+	// Ping serves GET /ping.
+	Ping Endpoint = "ping"
+)
+
+// Endpoints are all endpoints, by default all of them are enabled.
+var Endpoints = []Endpoint{Calamares, Ubiquity, Debug, Meta, Doc, Ping}
+
+type options struct {
+	dbs            *database.Set
+	locator        locator.Locator
+	endpoints      []Endpoint
+	logger         *log.Logger
+	trustedProxies []string
+	trustProxies   bool
+	prefix         string
+	meta           func() models.Meta
+	attribution    func() string
+	docs           string
+	updaters       []func(stop <-chan struct{})
+	admin          []binding
+	timeout        time.Duration
+}
+
+// A handler served on a listener of its own.
+type binding struct {
+	listener net.Listener
+	handler  http.Handler
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+// WithDatabases sets the databases to serve. Without it the Server serves
+// an empty set, which only makes sense along with WithLocator.
+func WithDatabases(dbs *database.Set) Option {
+	return func(o *options) { o.dbs = dbs }
+}
+
+// WithLocator sets where locations are looked up (default: the City
+// database of WithDatabases).
+func WithLocator(l locator.Locator) Option {
+	return func(o *options) { o.locator = l }
+}
+
+// WithEndpoints enables only the given endpoints.
+func WithEndpoints(endpoints ...Endpoint) Option {
+	return func(o *options) { o.endpoints = endpoints }
+}
+
+// WithLogger logs requests and recovered panics to logger instead of
+// gin's default writers.
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithTrustedProxies only takes the client IP from X-Forwarded-For and
+// X-Real-IP if the request comes from one of proxies (IPs or CIDRs). Without
+// any proxies the headers are always ignored. Without this option every
+// proxy is trusted.
+func WithTrustedProxies(proxies ...string) Option {
+	return func(o *options) {
+		o.trustedProxies = proxies
+		o.trustProxies = true
+	}
+}
+
+// WithPrefix serves all routes below prefix (e.g. /geoip).
+func WithPrefix(prefix string) Option {
+	return func(o *options) { o.prefix = prefix }
+}
+
+// WithMeta sets what GET /v1/meta answers with.
+func WithMeta(meta func() models.Meta) Option {
+	return func(o *options) { o.meta = meta }
+}
+
+// WithAttribution sets the notice sent along with every response (see
+// apis.Attribution).
+func WithAttribution(notice func() string) Option {
+	return func(o *options) { o.attribution = notice }
+}
+
+// WithDocs sets the directory of the API documentation (default: doc).
+func WithDocs(dir string) Option {
+	return func(o *options) { o.docs = dir }
+}
+
+// WithUpdater runs update alongside Run (e.g. to keep the databases up to
+// date). stop gets closed when Run shuts down. May be given more than once.
+func WithUpdater(update func(stop <-chan struct{})) Option {
+	return func(o *options) { o.updaters = append(o.updaters, update) }
+}
+
+// WithAdmin serves handler on listener alongside Run, e.g. administrative
+// routes that are not meant for the public listeners. May be given more
+// than once.
+func WithAdmin(listener net.Listener, handler http.Handler) Option {
+	return func(o *options) { o.admin = append(o.admin, binding{listener, handler}) }
+}
+
+// WithShutdownTimeout sets how long Run waits for requests in flight when
+// shutting down (default: 4 seconds).
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.timeout = timeout }
+}
+
+// Server serves the GeoIP endpoints.
+type Server struct {
+	engine *gin.Engine
+	opts   *options
+}
+
+// New creates a Server configured by opts.
+func New(opts ...Option) (*Server, error) {
+	o := &options{endpoints: Endpoints, docs: "doc", timeout: 4 * time.Second}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.dbs == nil {
+		o.dbs = database.NewSet()
+	}
+	if o.locator == nil {
+		o.locator = locator.New(o.dbs)
+	}
+	prefix := "/" + strings.Trim(o.prefix, "/")
+
+	engine := gin.New()
+	if o.logger != nil {
+		engine.Use(gin.LoggerWithWriter(o.logger.Writer()), gin.RecoveryWithWriter(o.logger.Writer()))
+	} else {
+		engine.Use(gin.Logger(), gin.Recovery())
+	}
+	if o.trustProxies {
+		if err := engine.SetTrustedProxies(o.trustedProxies); err != nil {
+			return nil, err
+		}
+	}
+	if o.attribution != nil {
+		engine.Use(apis.Attribution(o.attribution))
+	}
+
+	rg := engine.Group(prefix)
+	enabled := map[Endpoint]bool{}
+	for _, endpoint := range o.endpoints {
+		if enabled[endpoint] {
+			return nil, fmt.Errorf("endpoint %q enabled twice", endpoint)
+		}
+		enabled[endpoint] = true
+		switch endpoint {
+		case Calamares:
+			apis.ServeCalamaresResource(rg, o.locator)
+		case Ubiquity:
+			apis.ServeUbiquityResource(rg, o.locator)
+		case Debug:
+			apis.ServeDebugResource(rg, o.locator)
+		case Meta:
+			if o.meta != nil {
+				apis.ServeMetaResource(rg, o.meta)
+			}
+		case Doc:
+			doc := path.Join(prefix, "doc")
+			rg.GET("/", func(c *gin.Context) {
+				c.Redirect(http.StatusMovedPermanently, doc)
+			})
+			rg.StaticFS("/doc", http.Dir(o.docs))
+		case Ping:
+			rg.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "OK") })
+		default:
+			return nil, fmt.Errorf("unknown endpoint %q", endpoint)
+		}
+	}
+	return &Server{engine, o}, nil
+}
+
+// ServeHTTP serves the endpoints.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.engine.ServeHTTP(w, r)
+}
+
+// Run serves the endpoints on listeners (and WithAdmin handlers on theirs)
+// and runs the WithUpdater funcs until ctx is done or serving fails. It then
+// stops the updaters and shuts down gracefully: the listeners are closed
+// right away, requests in flight get WithShutdownTimeout to finish.
+func (s *Server) Run(ctx context.Context, listeners ...net.Listener) error {
+	if len(listeners) == 0 {
+		return errors.New("no listeners to serve on")
+	}
+	bindings := append([]binding{}, s.opts.admin...)
+	for _, listener := range listeners {
+		bindings = append(bindings, binding{listener, s})
+	}
+
+	stop := make(chan struct{})
+	for _, update := range s.opts.updaters {
+		go update(stop)
+	}
+
+	failed := make(chan error, len(bindings))
+	var servers []*http.Server
+	for _, b := range bindings {
+		server := &http.Server{Handler: b.handler}
+		go func(listener net.Listener) { failed <- server.Serve(listener) }(b.listener)
+		servers = append(servers, server)
+	}
+
+	var err error
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+	close(stop)
+
+	shutdown, cancel := context.WithTimeout(context.Background(), s.opts.timeout)
+	defer cancel()
+	for _, server := range servers {
+		server.SetKeepAlivesEnabled(false)
+		if shutdownErr := server.Shutdown(shutdown); err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
+}
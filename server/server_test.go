@@ -0,0 +1,181 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/apachelogger/geoip-kde-org/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func newServer(t *testing.T, opts ...Option) *Server {
+	dbs := database.NewSet()
+	dbtest.Load(t, dbs.City)
+	s, err := New(append([]Option{WithDatabases(dbs)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func get(s http.Handler, url string, remote string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", url, nil)
+	req.RemoteAddr = remote + ":1234"
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res := httptest.NewRecorder()
+	s.ServeHTTP(res, req)
+	return res
+}
+
+func TestDefaults(t *testing.T) {
+	s := newServer(t)
+	res := get(s, "/v1/calamares", dbtest.Vienna, nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.JSONEq(t, `{"time_zone":"Europe/Vienna"}`, res.Body.String())
+	assert.Equal(t, http.StatusOK, get(s, "/v1/ubiquity", dbtest.Vienna, nil).Code)
+	assert.Equal(t, http.StatusOK, get(s, "/debug", dbtest.Vienna, nil).Code)
+	assert.Equal(t, http.StatusOK, get(s, "/ping", dbtest.Vienna, nil).Code)
+	assert.Equal(t, http.StatusMovedPermanently, get(s, "/", dbtest.Vienna, nil).Code)
+	// Without WithMeta there is nothing to tell.
+	assert.Equal(t, http.StatusNotFound, get(s, "/v1/meta", dbtest.Vienna, nil).Code)
+}
+
+func TestEndpoints(t *testing.T) {
+	s := newServer(t, WithEndpoints(Calamares))
+	assert.Equal(t, http.StatusOK, get(s, "/v1/calamares", dbtest.Vienna, nil).Code)
+	assert.Equal(t, http.StatusNotFound, get(s, "/v1/ubiquity", dbtest.Vienna, nil).Code)
+	assert.Equal(t, http.StatusNotFound, get(s, "/ping", dbtest.Vienna, nil).Code)
+
+	_, err := New(WithEndpoints("nope"))
+	assert.Error(t, err)
+	_, err = New(WithEndpoints(Ping, Ping))
+	assert.Error(t, err)
+}
+
+func TestPrefix(t *testing.T) {
+	s := newServer(t, WithPrefix("/geoip/"))
+	assert.Equal(t, http.StatusOK, get(s, "/geoip/v1/calamares", dbtest.Vienna, nil).Code)
+	assert.Equal(t, http.StatusNotFound, get(s, "/v1/calamares", dbtest.Vienna, nil).Code)
+	res := get(s, "/geoip/", dbtest.Vienna, nil)
+	assert.Equal(t, http.StatusMovedPermanently, res.Code)
+	assert.Equal(t, "/geoip/doc", res.Header().Get("Location"))
+}
+
+func TestTrustedProxies(t *testing.T) {
+	forwarded := http.Header{"X-Forwarded-For": {dbtest.NoCity}}
+	// By default every proxy is trusted.
+	s := newServer(t)
+	res := get(s, "/v1/calamares", dbtest.Private, forwarded)
+	assert.JSONEq(t, `{"time_zone":"Europe/London"}`, res.Body.String())
+
+	s = newServer(t, WithTrustedProxies("127.0.0.1"))
+	res = get(s, "/v1/calamares", dbtest.Private, forwarded)
+	assert.JSONEq(t, `{"time_zone":"Asia/Kabul"}`, res.Body.String())
+	res = get(s, "/v1/calamares", "127.0.0.1", forwarded)
+	assert.JSONEq(t, `{"time_zone":"Europe/London"}`, res.Body.String())
+
+	_, err := New(WithTrustedProxies("not an ip"))
+	assert.Error(t, err)
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s := newServer(t, WithLogger(log.New(&buf, "", 0)))
+	get(s, "/ping", dbtest.Vienna, nil)
+	assert.Contains(t, buf.String(), "/ping")
+}
+
+func TestMetaAndAttribution(t *testing.T) {
+	s := newServer(t,
+		WithMeta(func() models.Meta { return models.Meta{Version: "1.0"} }),
+		WithAttribution(func() string { return "notice" }))
+	res := get(s, "/v1/meta", dbtest.Vienna, nil)
+	assert.Equal(t, http.StatusOK, res.Code)
+	assert.Contains(t, res.Body.String(), `"1.0"`)
+	assert.Equal(t, "notice", res.Header().Get("X-Data-Attribution"))
+}
+
+func TestRun(t *testing.T) {
+	public, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	s := newServer(t,
+		WithAdmin(admin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("admin"))
+		})),
+		WithUpdater(func(stop <-chan struct{}) {
+			<-stop
+			close(stopped)
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- s.Run(ctx, public) }()
+
+	res, err := http.Get("http://" + public.Addr().String() + "/ping")
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, "OK", string(body))
+	}
+	res, err = http.Get("http://" + admin.Addr().String() + "/")
+	if assert.NoError(t, err) {
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, "admin", string(body))
+	}
+
+	cancel()
+	assert.NoError(t, <-done)
+	<-stopped
+	_, err = http.Get("http://" + public.Addr().String() + "/ping")
+	assert.Error(t, err, "no longer listening")
+}
+
+func TestRunFailure(t *testing.T) {
+	s := newServer(t)
+	assert.Error(t, s.Run(context.Background()))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+	assert.Error(t, s.Run(context.Background(), listener))
+}
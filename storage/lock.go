@@ -0,0 +1,45 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Lock takes an exclusive advisory lock on the store, blocking until it is
+// available. Processes sharing Dir (e.g. a draining instance and its socket
+// activated successor) should hold it while changing the store so only one
+// of them downloads and the others get to reuse the result. Call the
+// returned func to unlock.
+func (s *Store) Lock() (func() error, error) {
+	file, err := os.OpenFile(filepath.Join(s.Dir, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, err
+	}
+	// Closing releases the lock.
+	return file.Close, nil
+}
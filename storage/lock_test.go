@@ -0,0 +1,60 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreLock(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+
+	unlock, err := s.Lock()
+	assert.NoError(t, err)
+
+	// flock locks are per open file, so this contends like another
+	// process would.
+	locked := make(chan func() error)
+	go func() {
+		unlock, err := s.Lock()
+		assert.NoError(t, err)
+		locked <- unlock
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("got lock while held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	assert.NoError(t, unlock())
+	select {
+	case unlock := <-locked:
+		assert.NoError(t, unlock())
+	case <-time.After(time.Second):
+		t.Fatal("didn't get lock after release")
+	}
+}
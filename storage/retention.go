@@ -0,0 +1,201 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// When a build got superseded, that is when the next newer one we have was
+// installed. Zero if there is none.
+func (s *Store) supersededAt(edition string, versions []uint, i int) (time.Time, error) {
+	if i+1 >= len(versions) {
+		return time.Time{}, nil
+	}
+	info, err := os.Stat(s.VersionPath(edition, versions[i+1]))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Expire removes the builds of edition that were superseded by a newer one
+// longer than window ago and returns their paths. Licences such as the
+// GeoLite2 EULA require outdated databases to be deleted. The current and
+// the pinned build are kept regardless, see Superseded.
+func (s *Store) Expire(edition string, window time.Duration) ([]string, error) {
+	versions, err := s.Versions(edition)
+	if err != nil {
+		return nil, err
+	}
+	current, err := s.Current(edition)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	pinned, _, err := s.Pinned(edition)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for i, epoch := range versions {
+		if epoch == current || epoch == pinned {
+			continue
+		}
+		at, err := s.supersededAt(edition, versions, i)
+		if err != nil {
+			return removed, err
+		}
+		if at.IsZero() || time.Since(at) <= window {
+			continue
+		}
+		path := s.VersionPath(edition, epoch)
+		if err := os.Remove(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+	return removed, nil
+}
+
+// Superseded returns since when a newer build than the current one of
+// edition is around, false if the current one is the newest.
+func (s *Store) Superseded(edition string) (time.Time, bool, error) {
+	versions, err := s.Versions(edition)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	current, err := s.Current(edition)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for i, epoch := range versions {
+		if epoch != current {
+			continue
+		}
+		at, err := s.supersededAt(edition, versions, i)
+		return at, !at.IsZero(), err
+	}
+	return time.Time{}, false, nil
+}
+
+// Editions lists the editions the store has builds of.
+func (s *Store) Editions() ([]string, error) {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var editions []string
+	for _, info := range files {
+		name := info.Name()
+		if strings.HasPrefix(name, ".") || !strings.HasSuffix(name, ".mmdb") {
+			continue
+		}
+		edition := strings.TrimSuffix(name, ".mmdb")
+		if i := strings.LastIndex(edition, "_"); i > 0 {
+			if _, err := strconv.ParseUint(edition[i+1:], 10, 0); err == nil {
+				edition = edition[:i]
+			}
+		}
+		if !seen[edition] {
+			seen[edition] = true
+			editions = append(editions, edition)
+		}
+	}
+	sort.Strings(editions)
+	return editions, nil
+}
+
+func (s *Store) droppedPath(edition string) string {
+	return filepath.Join(s.Dir, edition+".dropped")
+}
+
+// ExpireDropped removes all builds of the editions in the store that are not
+// among editions once they have been dropped for longer than window and
+// returns their paths. Licences keep applying to builds nobody serves
+// anymore, the build that was in use included. Dropping counts as
+// superseding: the first call that finds an edition missing records when,
+// configuring it again before the window is up keeps its builds.
+func (s *Store) ExpireDropped(editions []string, window time.Duration) ([]string, error) {
+	configured := map[string]bool{}
+	for _, edition := range editions {
+		configured[edition] = true
+		if err := os.Remove(s.droppedPath(edition)); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	stored, err := s.Editions()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, edition := range stored {
+		if configured[edition] {
+			continue
+		}
+		info, err := os.Stat(s.droppedPath(edition))
+		if os.IsNotExist(err) {
+			if err := ioutil.WriteFile(s.droppedPath(edition), nil, 0644); err != nil {
+				return removed, err
+			}
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		if time.Since(info.ModTime()) <= window {
+			continue
+		}
+
+		versions, err := s.Versions(edition)
+		if err != nil {
+			return removed, err
+		}
+		paths := []string{s.Path(edition)}
+		for _, epoch := range versions {
+			paths = append(paths, s.VersionPath(edition, epoch))
+		}
+		for _, path := range paths {
+			if err := os.Remove(path); os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return removed, err
+			}
+			removed = append(removed, path)
+		}
+		// Only once the builds are gone, should that fail the next call
+		// retries.
+		for _, path := range []string{s.metaPath(edition), s.pinPath(edition), s.droppedPath(edition)} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,117 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreExpire(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 100, 200, 300, 400)
+	day := 24 * time.Hour
+	for epoch, age := range map[uint]time.Duration{100: 50 * day, 200: 40 * day, 300: 35 * day, 400: 10 * day} {
+		installed := time.Now().Add(-age)
+		assert.NoError(t, os.Chtimes(s.VersionPath("GeoLite2-City", epoch), installed, installed))
+	}
+	// Rolled back to a build superseded long ago.
+	assert.NoError(t, s.Pin("GeoLite2-City", 200))
+
+	at, superseded, err := s.Superseded("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.True(t, superseded)
+	assert.WithinDuration(t, time.Now().Add(-35*day), at, time.Minute)
+
+	removed, err := s.Expire("GeoLite2-City", 30*day)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{s.VersionPath("GeoLite2-City", 100)}, removed)
+	versions, err := s.Versions("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{200, 300, 400}, versions)
+
+	assert.NoError(t, s.Unpin("GeoLite2-City"))
+	assert.NoError(t, s.Use("GeoLite2-City", 400))
+	_, superseded, err = s.Superseded("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.False(t, superseded)
+	removed, err = s.Expire("GeoLite2-City", 30*day)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{s.VersionPath("GeoLite2-City", 200)}, removed)
+}
+
+func TestStoreExpireDropped(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 100, 200)
+	assert.NoError(t, s.WriteMeta("GeoLite2-City", map[string]string{"etag": "x"}))
+	dbip := filepath.Join(s.Dir, "dbip-city-lite_300.mmdb")
+	assert.NoError(t, ioutil.WriteFile(dbip, []byte("db"), 0644))
+
+	editions, err := s.Editions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"GeoLite2-City", "dbip-city-lite"}, editions)
+
+	day := 24 * time.Hour
+	// Switched from maxmind to dbip.
+	removed, err := s.ExpireDropped([]string{"dbip-city-lite"}, 30*day)
+	assert.NoError(t, err)
+	assert.Empty(t, removed, "only just dropped")
+
+	// And back before the window was up.
+	removed, err = s.ExpireDropped([]string{"GeoLite2-City"}, 30*day)
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+	long := time.Now().Add(-31 * day)
+	assert.NoError(t, os.Chtimes(s.droppedPath("dbip-city-lite"), long, long))
+	assert.NoError(t, os.Chtimes(dbip, long, long))
+	_, err = os.Stat(s.droppedPath("GeoLite2-City"))
+	assert.True(t, os.IsNotExist(err), "configured again")
+
+	// Dropped again, this time for good.
+	removed, err = s.ExpireDropped([]string{"dbip-city-lite"}, 30*day)
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+	assert.NoError(t, os.Chtimes(s.droppedPath("GeoLite2-City"), long, long))
+	removed, err = s.ExpireDropped([]string{"dbip-city-lite"}, 30*day)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		s.Path("GeoLite2-City"),
+		s.VersionPath("GeoLite2-City", 100),
+		s.VersionPath("GeoLite2-City", 200),
+	}, removed)
+	_, err = os.Stat(s.metaPath("GeoLite2-City"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(s.droppedPath("GeoLite2-City"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dbip)
+	assert.NoError(t, err, "configured")
+
+	editions, err = s.Editions()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dbip-city-lite"}, editions)
+}
@@ -0,0 +1,369 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	geoip2 "github.com/oschwald/geoip2-golang"
+)
+
+// Prefix of all files that are not ready to be used yet. Anything by that
+// name is garbage once the writing process is gone.
+const partialPrefix = ".partial-"
+
+// Store keeps versioned database files in Dir. Every edition has a stable
+// symlink (e.g. GeoLite2-City.mmdb) pointing at the currently promoted build
+// (e.g. GeoLite2-City_1523952000.mmdb, the number being its build epoch).
+// Switching builds only ever renames a fresh symlink over the stable one,
+// so whoever opens the stable path always gets a complete file.
+type Store struct {
+	Dir string
+	// Validate, if set, gets to veto new builds of an edition before they
+	// are promoted.
+	Validate func(edition string, path string) error
+}
+
+// Path returns the stable path of edition. This is what should be opened.
+func (s *Store) Path(edition string) string {
+	return filepath.Join(s.Dir, edition+".mmdb")
+}
+
+// VersionPath returns the path of a specific build of edition.
+func (s *Store) VersionPath(edition string, epoch uint) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%s_%d.mmdb", edition, epoch))
+}
+
+// Versions lists the builds of edition in the store, oldest first.
+func (s *Store) Versions(edition string) ([]uint, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, edition+"_*.mmdb"))
+	if err != nil {
+		return nil, err
+	}
+	var versions []uint
+	for _, match := range matches {
+		if epoch, ok := parseVersion(edition, filepath.Base(match)); ok {
+			versions = append(versions, epoch)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions, nil
+}
+
+// Current returns the build the stable path of edition points at.
+func (s *Store) Current(edition string) (uint, error) {
+	target, err := os.Readlink(s.Path(edition))
+	if err != nil {
+		return 0, err
+	}
+	epoch, ok := parseVersion(edition, filepath.Base(target))
+	if !ok {
+		return 0, fmt.Errorf("%s points at unversioned %s", s.Path(edition), target)
+	}
+	return epoch, nil
+}
+
+// Previous returns the newest build of edition older than the current one.
+func (s *Store) Previous(edition string) (uint, error) {
+	current, err := s.Current(edition)
+	if err != nil {
+		return 0, err
+	}
+	versions, err := s.Versions(edition)
+	if err != nil {
+		return 0, err
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i] < current {
+			return versions[i], nil
+		}
+	}
+	return 0, fmt.Errorf("no build of %s older than %d", edition, current)
+}
+
+// Use switches the stable path of edition over to an already stored build.
+func (s *Store) Use(edition string, epoch uint) error {
+	path := s.VersionPath(edition, epoch)
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	return s.link(edition, path)
+}
+
+// Pin switches edition over to a build and keeps it there. While pinned the
+// updater must not promote new builds.
+func (s *Store) Pin(edition string, epoch uint) error {
+	if err := s.Use(edition, epoch); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.pinPath(edition), []byte(fmt.Sprintf("%d\n", epoch)), 0644)
+}
+
+// Unpin releases a Pin. Unpinning an edition that isn't pinned is fine.
+func (s *Store) Unpin(edition string) error {
+	err := os.Remove(s.pinPath(edition))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Pinned returns the build edition is pinned to, if any.
+func (s *Store) Pinned(edition string) (uint, bool, error) {
+	data, err := ioutil.ReadFile(s.pinPath(edition))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	epoch, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 0)
+	if err != nil {
+		return 0, false, fmt.Errorf("malformed pin %s: %s", s.pinPath(edition), err)
+	}
+	return uint(epoch), true, nil
+}
+
+// Prune removes old builds of edition. The current build, a pinned build and
+// the keep newest other builds are retained.
+func (s *Store) Prune(edition string, keep int) error {
+	versions, err := s.Versions(edition)
+	if err != nil {
+		return err
+	}
+	current, err := s.Current(edition)
+	if err != nil {
+		return err
+	}
+	pinned, _, err := s.Pinned(edition)
+	if err != nil {
+		return err
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		epoch := versions[i]
+		if epoch == current || epoch == pinned {
+			continue
+		}
+		if keep > 0 {
+			keep--
+			continue
+		}
+		if err := os.Remove(s.VersionPath(edition, epoch)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMeta decodes the metadata stored for edition into v. v is left alone
+// if there is none.
+func (s *Store) ReadMeta(edition string, v interface{}) error {
+	data, err := ioutil.ReadFile(s.metaPath(edition))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// WriteMeta atomically replaces the metadata stored for edition with v.
+// What goes in there is up to the caller, e.g. where the current build came
+// from.
+func (s *Store) WriteMeta(edition string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(s.Dir, partialPrefix+edition)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name()) // no-op once renamed
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), s.metaPath(edition))
+}
+
+// Install writes a new build of edition through fill and promotes it.
+// fill gets a partial file to write to, should it fail or the result not
+// be a valid database (or be rejected by Validate) the partial file is
+// removed again and the currently promoted build stays in place. Returns
+// the path of the new build.
+func (s *Store) Install(edition string, fill func(w io.Writer) error) (string, error) {
+	file, err := ioutil.TempFile(s.Dir, partialPrefix+edition)
+	if err != nil {
+		return "", err
+	}
+	partial := file.Name()
+	defer os.Remove(partial) // no-op once renamed
+
+	err = fill(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	if err = os.Chmod(partial, 0644); err != nil {
+		return "", err
+	}
+
+	epoch, err := buildEpoch(partial)
+	if err != nil {
+		return "", err
+	}
+	if s.Validate != nil {
+		if err = s.Validate(edition, partial); err != nil {
+			return "", err
+		}
+	}
+
+	path := s.VersionPath(edition, epoch)
+	if err = os.Rename(partial, path); err != nil {
+		return "", err
+	}
+	return path, s.link(edition, path)
+}
+
+// Adopt installs a regular file found at the stable path of edition (e.g.
+// moved there by hand) as new build, replacing it with the usual symlink.
+// Returns the path of the new build, or "" if there was nothing to adopt.
+// Should the file not pass Install it stays where it is.
+func (s *Store) Adopt(edition string) (string, error) {
+	info, err := os.Lstat(s.Path(edition))
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", nil
+	}
+	return s.Install(edition, func(w io.Writer) error {
+		file, err := os.Open(s.Path(edition))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(w, file)
+		return err
+	})
+}
+
+func (s *Store) metaPath(edition string) string {
+	return filepath.Join(s.Dir, edition+".meta")
+}
+
+func (s *Store) pinPath(edition string) string {
+	return filepath.Join(s.Dir, edition+".pin")
+}
+
+// Atomically points the stable path of edition at target.
+func (s *Store) link(edition string, target string) error {
+	tmp := filepath.Join(s.Dir, partialPrefix+edition+".link")
+	os.Remove(tmp) // may be left over from a crash
+	// Relative target so the directory may be moved around.
+	if err := os.Symlink(filepath.Base(target), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.Path(edition)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(s.Dir)
+}
+
+// StagingDir is a directory for downloads to be staged in before they get
+// installed, Clean empties it.
+func (s *Store) StagingDir() string {
+	return filepath.Join(s.Dir, partialPrefix+"downloads")
+}
+
+// Clean removes partial files left behind by an interrupted Install or
+// download.
+func (s *Store) Clean() error {
+	files, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range files {
+		if !strings.HasPrefix(info.Name(), partialPrefix) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.Dir, info.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Parses the build epoch out of a VersionPath basename.
+func parseVersion(edition string, name string) (uint, bool) {
+	if !strings.HasPrefix(name, edition+"_") || !strings.HasSuffix(name, ".mmdb") {
+		return 0, false
+	}
+	version := strings.TrimSuffix(strings.TrimPrefix(name, edition+"_"), ".mmdb")
+	epoch, err := strconv.ParseUint(version, 10, 0)
+	if err != nil {
+		return 0, false
+	}
+	return uint(epoch), true
+}
+
+// Opening the file doubles as check that we have a database at all.
+func buildEpoch(path string) (uint, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+	return db.Metadata().BuildEpoch, nil
+}
+
+// Makes renames inside dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
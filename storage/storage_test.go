@@ -0,0 +1,300 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func tempStore(t *testing.T) *Store {
+	dir, err := ioutil.TempDir("", "geoip-storage-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Store{Dir: dir}
+}
+
+func TestStoreInstall(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+
+	path, err := s.Install("GeoLite2-City", func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{BuildEpoch: 1523952000})
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(s.Dir, "GeoLite2-City_1523952000.mmdb"), path)
+	target, err := os.Readlink(s.Path("GeoLite2-City"))
+	assert.NoError(t, err)
+	assert.Equal(t, "GeoLite2-City_1523952000.mmdb", target)
+
+	path, err = s.Install("GeoLite2-City", func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{BuildEpoch: 1524556800})
+	})
+	assert.NoError(t, err)
+	target, err = os.Readlink(s.Path("GeoLite2-City"))
+	assert.NoError(t, err)
+	assert.Equal(t, "GeoLite2-City_1524556800.mmdb", target)
+	// The previous build is still around.
+	_, err = os.Stat(s.VersionPath("GeoLite2-City", 1523952000))
+	assert.NoError(t, err)
+}
+
+func TestStoreInstallReplacesRegularFile(t *testing.T) {
+	// Databases used to be written straight to the stable path.
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	assert.NoError(t, ioutil.WriteFile(s.Path("GeoLite2-City"), []byte("legacy"), 0644))
+
+	_, err := s.Install("GeoLite2-City", func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{BuildEpoch: 1523952000})
+	})
+	assert.NoError(t, err)
+	target, err := os.Readlink(s.Path("GeoLite2-City"))
+	assert.NoError(t, err)
+	assert.Equal(t, "GeoLite2-City_1523952000.mmdb", target)
+}
+
+func TestStoreAdopt(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 1523952000)
+
+	// Nothing to adopt while the stable path is ours.
+	path, err := s.Adopt("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Empty(t, path)
+
+	// mv'ing a database in replaces the symlink.
+	moved := filepath.Join(s.Dir, "moved.mmdb")
+	dbtest.WriteFile(t, moved, dbtest.Options{BuildEpoch: 1524556800})
+	assert.NoError(t, os.Rename(moved, s.Path("GeoLite2-City")))
+	path, err = s.Adopt("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, s.VersionPath("GeoLite2-City", 1524556800), path)
+	current, err := s.Current("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1524556800), current)
+	previous, err := s.Previous("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1523952000), previous)
+
+	// Garbage stays where it is.
+	assert.NoError(t, os.Remove(s.Path("GeoLite2-City")))
+	assert.NoError(t, ioutil.WriteFile(s.Path("GeoLite2-City"), []byte("garbage"), 0644))
+	_, err = s.Adopt("GeoLite2-City")
+	assert.Error(t, err)
+	data, err := ioutil.ReadFile(s.Path("GeoLite2-City"))
+	assert.NoError(t, err)
+	assert.Equal(t, "garbage", string(data))
+}
+
+func TestStoreInstallFailure(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	_, err := s.Install("GeoLite2-City", func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{BuildEpoch: 1523952000})
+	})
+	assert.NoError(t, err)
+
+	_, err = s.Install("GeoLite2-City", func(w io.Writer) error {
+		w.Write([]byte("half a datab"))
+		return errors.New("connection reset")
+	})
+	assert.Error(t, err)
+	_, err = s.Install("GeoLite2-City", func(w io.Writer) error {
+		_, err := w.Write([]byte("not a database"))
+		return err
+	})
+	assert.Error(t, err)
+
+	// Still on the old build and no partial files lying around.
+	target, err := os.Readlink(s.Path("GeoLite2-City"))
+	assert.NoError(t, err)
+	assert.Equal(t, "GeoLite2-City_1523952000.mmdb", target)
+	files, err := ioutil.ReadDir(s.Dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+}
+
+// Installs builds at the given epochs, the last one ends up current.
+func installVersions(t *testing.T, s *Store, epochs ...int64) {
+	for _, epoch := range epochs {
+		_, err := s.Install("GeoLite2-City", func(w io.Writer) error {
+			return dbtest.Write(w, dbtest.Options{BuildEpoch: epoch})
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestStoreVersions(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 300, 100, 200)
+	// Not ours.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(s.Dir, "GeoLite2-City_backup.mmdb"), nil, 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(s.Dir, "GeoLite2-Country_400.mmdb"), nil, 0644))
+
+	versions, err := s.Versions("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{100, 200, 300}, versions)
+	current, err := s.Current("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(200), current)
+	previous, err := s.Previous("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(100), previous)
+}
+
+func TestStoreUse(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 100, 200)
+
+	assert.NoError(t, s.Use("GeoLite2-City", 100))
+	current, err := s.Current("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(100), current)
+	_, err = s.Previous("GeoLite2-City")
+	assert.Error(t, err)
+
+	assert.Error(t, s.Use("GeoLite2-City", 150))
+	current, err = s.Current("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(100), current)
+}
+
+func TestStorePin(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 100, 200)
+
+	_, pinned, err := s.Pinned("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.False(t, pinned)
+
+	assert.NoError(t, s.Pin("GeoLite2-City", 100))
+	epoch, pinned, err := s.Pinned("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.True(t, pinned)
+	assert.Equal(t, uint(100), epoch)
+	current, err := s.Current("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(100), current)
+
+	assert.NoError(t, s.Unpin("GeoLite2-City"))
+	assert.NoError(t, s.Unpin("GeoLite2-City"))
+	_, pinned, err = s.Pinned("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.False(t, pinned)
+}
+
+func TestStorePrune(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 100, 200, 300, 400, 500)
+	assert.NoError(t, s.Use("GeoLite2-City", 200))
+
+	assert.NoError(t, s.Prune("GeoLite2-City", 2))
+	versions, err := s.Versions("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{200, 400, 500}, versions)
+
+	assert.NoError(t, s.Prune("GeoLite2-City", 0))
+	versions, err = s.Versions("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{200}, versions)
+}
+
+func TestStoreInstallValidate(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	installVersions(t, s, 100)
+
+	var validated string
+	s.Validate = func(edition string, path string) error {
+		assert.Equal(t, "GeoLite2-City", edition)
+		validated = path
+		return errors.New("canary died")
+	}
+	_, err := s.Install("GeoLite2-City", func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{BuildEpoch: 200})
+	})
+	assert.Error(t, err)
+	assert.Contains(t, validated, ".partial-")
+
+	versions, err := s.Versions("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{100}, versions)
+	current, err := s.Current("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(100), current)
+}
+
+func TestStoreMeta(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+
+	type meta struct {
+		ETag string
+	}
+	var m meta
+	assert.NoError(t, s.ReadMeta("GeoLite2-City", &m))
+	assert.Equal(t, meta{}, m)
+
+	assert.NoError(t, s.WriteMeta("GeoLite2-City", meta{ETag: "abc"}))
+	assert.NoError(t, s.WriteMeta("GeoLite2-City", meta{ETag: "def"}))
+	assert.NoError(t, s.ReadMeta("GeoLite2-City", &m))
+	assert.Equal(t, meta{ETag: "def"}, m)
+
+	files, err := ioutil.ReadDir(s.Dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestStoreClean(t *testing.T) {
+	s := tempStore(t)
+	defer os.RemoveAll(s.Dir)
+	partial := filepath.Join(s.Dir, ".partial-GeoLite2-City123")
+	assert.NoError(t, ioutil.WriteFile(partial, []byte("crashed"), 0644))
+	assert.NoError(t, os.Mkdir(s.StagingDir(), 0755))
+	download := filepath.Join(s.StagingDir(), "geoip-geolite2-city123")
+	assert.NoError(t, ioutil.WriteFile(download, []byte("crashed"), 0644))
+	keep := filepath.Join(s.Dir, "GeoLite2-City_1523952000.mmdb")
+	assert.NoError(t, ioutil.WriteFile(keep, []byte("db"), 0644))
+
+	assert.NoError(t, s.Clean())
+	_, err := os.Stat(partial)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(s.StagingDir())
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(keep)
+	assert.NoError(t, err)
+}
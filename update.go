@@ -0,0 +1,506 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apachelogger/geoip-kde-org/canary"
+	"github.com/apachelogger/geoip-kde-org/database"
+	"github.com/apachelogger/geoip-kde-org/inspect"
+	"github.com/apachelogger/geoip-kde-org/storage"
+	"github.com/apachelogger/geoip-kde-org/updater"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// The editions we keep up to date and serve. One per kind (see
+// database.Set), City is mandatory.
+var editions = editionList{"GeoLite2-City"}
+
+// Whether editions came from a GeoIP.conf rather than being left to the
+// provider.
+var explicitEditions = false
+
+// The City edition, the one primarily served.
+var cityEdition = "GeoLite2-City"
+
+func init() {
+	flag.Var(&editions, "editions", "comma separated `list` of editions to keep up to date and serve")
+}
+
+// editionList is a flag.Value of comma separated editions.
+type editionList []string
+
+func (l *editionList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *editionList) Set(value string) error {
+	*l = strings.Split(value, ",")
+	return nil
+}
+
+func (l editionList) contains(edition string) bool {
+	for _, e := range l {
+		if e == edition {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether -editions was passed.
+func editionsFlagged() bool {
+	flagged := false
+	flag.Visit(func(f *flag.Flag) { flagged = flagged || f.Name == "editions" })
+	return flagged
+}
+
+// Makes sure we know how to serve all editions and picks the City one.
+func checkEditions() error {
+	kinds := map[*database.Database]string{}
+	cityEdition = ""
+	for _, edition := range editions {
+		db := dbs.ForEdition(edition)
+		if db == nil {
+			return fmt.Errorf("unsupported edition %s (only City, Country and ASN editions are)", edition)
+		}
+		if other, ok := kinds[db]; ok {
+			return fmt.Errorf("editions %s and %s are of the same kind, only one can be served", other, edition)
+		}
+		kinds[db] = edition
+		if db == dbs.City {
+			cityEdition = edition
+		}
+	}
+	if len(cityEdition) <= 0 {
+		return errors.New("editions need to include a City edition to serve")
+	}
+	return nil
+}
+
+// Combines independent failures.
+func joinErrors(errs []error) error {
+	if len(errs) <= 0 {
+		return nil
+	}
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+var maxmind = &updater.MaxMind{}
+
+var dbip = &updater.DBIP{}
+
+var s3 = &updater.S3{}
+
+var s3Publish = flag.Bool("s3-publish", false,
+	"upload every new build to the S3 bucket for -provider s3 instances to fetch")
+
+// The providers selectable with -provider and the editions they serve
+// unless configured otherwise.
+var providers = map[string]struct {
+	updater.Provider
+	editions editionList
+}{
+	"maxmind": {maxmind, editionList{"GeoLite2-City"}},
+	"dbip":    {dbip, editionList{"dbip-city-lite"}},
+	"s3":      {s3, editionList{"GeoLite2-City"}},
+	"leader":  {leader, editionList{"GeoLite2-City"}},
+}
+
+var providerName = flag.String("provider", envOr("GEOIP_PROVIDER", "maxmind"),
+	"where to get databases from: maxmind (needs an account), dbip (DB-IP Lite), s3 (a bucket populated with -s3-publish) or leader (another instance, see -leader-url)")
+
+var provider updater.Provider
+
+// Picks the -provider, defaulting the editions to its own.
+func selectProvider() error {
+	p, ok := providers[*providerName]
+	if !ok {
+		return fmt.Errorf("unknown provider %s (maxmind, dbip, s3 and leader are)", *providerName)
+	}
+	if *s3Publish && p.Provider == s3 {
+		return errors.New("-s3-publish makes no sense with -provider s3, the bucket is the source")
+	}
+	provider = p.Provider
+	leader.Token = *replicationToken
+	if !explicitEditions && !editionsFlagged() {
+		editions = p.editions
+	}
+	return nil
+}
+
+var geoipConf = flag.String("geoip-conf", os.Getenv("GEOIP_CONF"),
+	"geoipupdate `GeoIP.conf` to take credentials, editions and database directory from")
+
+var updateInterval = flag.Duration("update-interval", 24*time.Hour,
+	"how often to check for new database builds")
+
+var updateJitter = flag.Duration("update-jitter", time.Hour,
+	"up to how much random delay to add to every check so a fleet doesn't check in lockstep")
+
+var keep = flag.Int("keep", 2, "number of previous database builds to keep for rollbacks")
+
+var retention = flag.Duration("retention", 30*24*time.Hour,
+	"how long superseded builds and leftover downloads may be kept (the GeoLite2 EULA allows 30 days)")
+
+var canaries = &canary.Canaries{}
+
+var canaryFile = flag.String("canaries", "",
+	"file of `<ip> <country> [time zone]` lookups new database builds need to pass")
+
+func init() {
+	flag.Float64Var(&canaries.MinPass, "canary-threshold", 1,
+		"fraction (0-1) of canaries that need to pass for a new build to get promoted")
+}
+
+func init() {
+	flag.StringVar(&maxmind.BaseURL, "download-url", envOr("GEOIP_DOWNLOAD_URL", updater.DefaultBaseURL),
+		"base URL of the MaxMind download service")
+	flag.StringVar(&maxmind.AccountID, "account-id", os.Getenv("GEOIP_ACCOUNT_ID"),
+		"MaxMind account ID")
+	flag.StringVar(&maxmind.LicenseKey, "license-key", os.Getenv("GEOIP_LICENSE_KEY"),
+		"MaxMind licence key")
+	flag.StringVar(&dbip.BaseURL, "dbip-url", envOr("GEOIP_DBIP_URL", updater.DefaultDBIPURL),
+		"base URL of the DB-IP download service")
+	flag.StringVar(&s3.Endpoint, "s3-endpoint", os.Getenv("GEOIP_S3_ENDPOINT"),
+		"`host[:port]` of the S3 compatible service")
+	flag.BoolVar(&s3.Insecure, "s3-insecure", os.Getenv("GEOIP_S3_INSECURE") == "1",
+		"talk plain HTTP to the S3 service (e.g. a local MinIO)")
+	flag.StringVar(&s3.Region, "s3-region", os.Getenv("GEOIP_S3_REGION"), "S3 region (default us-east-1)")
+	flag.StringVar(&s3.Bucket, "s3-bucket", os.Getenv("GEOIP_S3_BUCKET"), "S3 bucket")
+	flag.StringVar(&s3.Prefix, "s3-prefix", os.Getenv("GEOIP_S3_PREFIX"),
+		"prefix of the databases' keys in the S3 bucket (e.g. geoip/)")
+	flag.StringVar(&s3.AccessKey, "s3-access-key", envOr("GEOIP_S3_ACCESS_KEY", os.Getenv("AWS_ACCESS_KEY_ID")),
+		"S3 access key")
+	flag.StringVar(&s3.SecretKey, "s3-secret-key", envOr("GEOIP_S3_SECRET_KEY", os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		"S3 secret key")
+}
+
+func envOr(key string, fallback string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// Guards canaries, they are used by updates and reloads alike.
+var canaryMu sync.Mutex
+
+// Runs the canaries against the database at path. They are re-read every
+// time so they can be changed without restart. Canaries are City lookups,
+// other editions pass unchecked.
+func checkCanaries(edition string, path string) error {
+	if dbs.ForEdition(edition) != dbs.City {
+		return nil
+	}
+
+	canaryMu.Lock()
+	defer canaryMu.Unlock()
+
+	if len(*canaryFile) > 0 {
+		assertions, err := canary.Load(*canaryFile)
+		if err != nil {
+			return err
+		}
+		canaries.Assertions = assertions
+	}
+	return canaries.Check(path)
+}
+
+// Refuses databases of another kind than edition (e.g. an ASN build in
+// place of the City one), they'd answer every lookup with an error.
+func checkEdition(edition string, path string) error {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	kind := db.Metadata().DatabaseType
+	if dbs.ForEdition(kind) != dbs.ForEdition(edition) {
+		return fmt.Errorf("%s is a %s database, not %s", path, kind, edition)
+	}
+	return nil
+}
+
+// Validates new builds before they get promoted.
+func validate(edition string, path string) error {
+	if err := checkEdition(edition, path); err != nil {
+		return err
+	}
+	logDiff(edition, path)
+	return checkCanaries(edition, path)
+}
+
+// Logs how the build at path differs from the current one so that big
+// networks moving to another country or time zone don't go unnoticed.
+func logDiff(edition string, path string) {
+	if dbs.ForEdition(edition) == dbs.ASN {
+		return // neither countries nor time zones
+	}
+	current := store.Path(edition)
+	if _, err := os.Stat(current); err != nil {
+		return // nothing to compare with
+	}
+	summary, err := inspect.Diff(current, path)
+	if err != nil {
+		log.Printf("Cannot diff %s builds: %s", edition, err)
+		return
+	}
+	log.Printf("%s %s", edition, summary)
+}
+
+var databaseDir = flag.String("database-dir", envOr("GEOIP_DATABASE_DIR", "."),
+	"`directory` to store the databases in")
+
+var store = &storage.Store{}
+
+func init() {
+	// Not in the literal, validate refers back to the store.
+	store.Validate = validate
+}
+
+// Applies the GeoIP.conf, if any. Everything else wins over it: flags,
+// environment variables and the config file.
+func loadGeoIPConf() error {
+	if len(*geoipConf) <= 0 {
+		return nil
+	}
+	conf, err := updater.LoadGeoIPConf(*geoipConf)
+	if err != nil {
+		return err
+	}
+
+	set := explicitlySet()
+	explicit := *maxmind
+	conf.Apply(maxmind)
+	if set["download-url"] {
+		maxmind.BaseURL = explicit.BaseURL
+	}
+	if set["account-id"] {
+		maxmind.AccountID = explicit.AccountID
+	}
+	if set["license-key"] {
+		maxmind.LicenseKey = explicit.LicenseKey
+	}
+	if len(conf.DatabaseDirectory) > 0 && !set["database-dir"] {
+		store.Dir = conf.DatabaseDirectory
+	}
+	if len(conf.EditionIDs) > 0 && !editionsFlagged() {
+		editions = conf.EditionIDs
+		explicitEditions = true
+	}
+	return nil
+}
+
+// What gets stored as metadata of an edition: the release of the last
+// download and which build that was. Rollbacks and imports make other
+// builds current, upstream must not be asked about those as if they were the
+// download.
+type buildMeta struct {
+	updater.Release
+	Epoch uint `json:"epoch,omitempty"`
+}
+
+// Returns whether a new build was installed.
+func downloadEdition(edition string) (bool, error) {
+	// The release tells upstream which build we have, unless we have lost
+	// the database in the meantime or serve another build than the one
+	// downloaded last.
+	var since updater.Release
+	if current, err := store.Current(edition); err == nil {
+		var meta buildMeta
+		if err := store.ReadMeta(edition, &meta); err != nil {
+			log.Printf("Failed to read %s metadata, forcing download: %s", edition, err)
+		} else if meta.Epoch == current {
+			since = meta.Release
+		}
+	}
+
+	var release updater.Release
+	_, err := store.Install(edition, func(w io.Writer) (err error) {
+		release, err = provider.Download(edition, since, w)
+		return err
+	})
+	if err == updater.ErrNotModified {
+		log.Printf("%s is up to date", edition)
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to update %s: %s", edition, err)
+	}
+	epoch, err := store.Current(edition)
+	if err == nil {
+		err = store.WriteMeta(edition, buildMeta{release, epoch})
+	}
+	if err != nil {
+		log.Printf("Failed to write %s metadata: %s", edition, err)
+	}
+	if err := store.Prune(edition, *keep); err != nil {
+		log.Printf("Failed to prune old builds: %s", err)
+	}
+	return true, nil
+}
+
+// Returns whether any new build was installed. Editions are updated
+// independently, a failing one doesn't hold up the others. The current
+// builds remain in place on failure so whether that is fatal is up to the
+// caller.
+func downloadGeoLite2() (bool, error) {
+	// Other instances may share the store. Whoever comes second waits and
+	// then finds everything up to date.
+	unlock, err := store.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	if err := store.Clean(); err != nil {
+		return false, err
+	}
+
+	updated := false
+	var failures []error
+	for _, edition := range editions {
+		if epoch, pinned, err := store.Pinned(edition); err != nil {
+			failures = append(failures, err)
+			continue
+		} else if pinned {
+			log.Printf("%s is pinned to build %d, not updating", edition, epoch)
+			if err := store.Use(edition, epoch); err != nil {
+				failures = append(failures, err)
+			}
+			continue
+		}
+
+		if ok, err := downloadEdition(edition); err != nil {
+			failures = append(failures, err)
+		} else if ok {
+			updated = true
+		}
+	}
+
+	if *s3Publish {
+		publish()
+	}
+	enforceRetention()
+	return updated, joinErrors(failures)
+}
+
+// Deletes what licences no longer allow us to keep. Builds still in use
+// can't be, they only get complained about.
+func enforceRetention() {
+	for _, edition := range editions {
+		removed, err := store.Expire(edition, *retention)
+		for _, path := range removed {
+			log.Printf("Deleted %s, superseded for more than %s", path, *retention)
+		}
+		if err != nil {
+			log.Printf("Failed to delete expired %s builds: %s", edition, err)
+		}
+
+		if at, superseded, err := store.Superseded(edition); err == nil && superseded && time.Since(at) > *retention {
+			log.Printf("The %s build in use has been superseded since %s, its licence may require deleting it. Unpin it!",
+				edition, at.Format(time.RFC3339))
+		}
+	}
+
+	// Also what is left of editions no longer configured (e.g. after
+	// switching providers).
+	removed, err := store.ExpireDropped(editions, *retention)
+	for _, path := range removed {
+		log.Printf("Deleted %s, its edition is no longer configured for more than %s", path, *retention)
+	}
+	if err != nil {
+		log.Printf("Failed to delete builds of dropped editions: %s", err)
+	}
+}
+
+// Uploads the current builds to the S3 bucket. Checked on every update so
+// an upload that failed or a rollback reaches the bucket eventually.
+func publish() {
+	for _, edition := range editions {
+		path, err := filepath.EvalSymlinks(store.Path(edition))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			log.Printf("Failed to publish %s: %s", edition, err)
+			continue
+		}
+		if published, err := s3.Publish(edition, path); err != nil {
+			log.Printf("Failed to publish %s: %s", edition, err)
+		} else if published {
+			log.Printf("Published %s to s3://%s/%s", filepath.Base(path), s3.Bucket, s3.Key(edition))
+		}
+	}
+}
+
+// Updates the databases and switches the served ones over if they changed.
+func update() {
+	if _, err := downloadGeoLite2(); err != nil {
+		log.Printf("Database update failed: %s", err)
+	}
+
+	loadMu.Lock()
+	defer loadMu.Unlock()
+	for _, edition := range editions {
+		// Not necessarily our download, another instance sharing the
+		// store may have beaten us to it.
+		if !changedOnDisk(edition) {
+			continue
+		}
+		if err := load(edition); err != nil {
+			log.Printf("Failed to load %s: %s", edition, err)
+		}
+	}
+}
+
+// Runs update right away and then on schedule until stop is closed.
+func updateLoop(stop <-chan struct{}) {
+	for {
+		scheduleUpdate(time.Time{})
+		update()
+
+		delay := *updateInterval
+		if *updateJitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(*updateJitter)))
+		}
+		scheduleUpdate(time.Now().Add(delay))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return
+		}
+	}
+}
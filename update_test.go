@@ -0,0 +1,104 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/apachelogger/geoip-kde-org/storage"
+	"github.com/apachelogger/geoip-kde-org/updater"
+	"github.com/stretchr/testify/assert"
+)
+
+// Stands in for upstream, serving the build at epoch and answering
+// conditional requests like upstream does.
+type stubProvider struct {
+	epoch int64
+}
+
+func (p *stubProvider) Download(edition string, since updater.Release, w io.Writer) (updater.Release, error) {
+	etag := fmt.Sprintf(`"%d"`, p.epoch)
+	if since.ETag == etag {
+		return updater.Release{}, updater.ErrNotModified
+	}
+	return updater.Release{ETag: etag}, dbtest.Write(w, dbtest.Options{BuildEpoch: p.epoch})
+}
+
+// Points the store at a temporary directory and the provider at a stub
+// until the test is done.
+func stubUpstream(t *testing.T) *stubProvider {
+	oldStore, oldProvider := store, provider
+	stub := &stubProvider{}
+	store = &storage.Store{Dir: t.TempDir(), Validate: validate}
+	provider = stub
+	t.Cleanup(func() { store, provider = oldStore, oldProvider })
+	return stub
+}
+
+func TestDownloadEditionAfterRollback(t *testing.T) {
+	const edition = "GeoLite2-City"
+	upstream := stubUpstream(t)
+
+	for _, epoch := range []int64{1523952000, 1524556800} {
+		upstream.epoch = epoch
+		updated, err := downloadEdition(edition)
+		assert.NoError(t, err)
+		assert.True(t, updated)
+	}
+	updated, err := downloadEdition(edition)
+	assert.NoError(t, err)
+	assert.False(t, updated, "upstream has nothing newer")
+
+	// db rollback followed by db unpin.
+	assert.NoError(t, store.Pin(edition, 1523952000))
+	assert.NoError(t, store.Unpin(edition))
+
+	// Upstream must not be asked whether the rolled back build is current.
+	updated, err = downloadEdition(edition)
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	current, err := store.Current(edition)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1524556800), current)
+
+	updated, err = downloadEdition(edition)
+	assert.NoError(t, err)
+	assert.False(t, updated)
+}
+
+func TestValidateEdition(t *testing.T) {
+	stubUpstream(t)
+
+	_, err := store.Install("GeoLite2-City", func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{DatabaseType: "GeoLite2-ASN"})
+	})
+	assert.Error(t, err)
+	_, err = store.Current("GeoLite2-City")
+	assert.Error(t, err, "nothing got promoted")
+
+	_, err = store.Install("GeoLite2-City", func(w io.Writer) error {
+		return dbtest.Write(w, dbtest.Options{DatabaseType: "DBIP-City-Lite"})
+	})
+	assert.NoError(t, err)
+}
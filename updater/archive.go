@@ -0,0 +1,85 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Where tar headers carry their magic.
+const tarMagicOffset = 257
+
+// Copies the mmdb of edition out of a tar stream as MaxMind ships them (a
+// dated directory with the mmdb and some licence noise next to it).
+func untar(r io.Reader, edition string, w io.Writer) error {
+	name := edition + ".mmdb"
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		info := header.FileInfo()
+		if info.Name() != name {
+			continue
+		}
+
+		_, err = io.Copy(w, tarReader)
+		return err
+	}
+	return fmt.Errorf("%s not found in %s archive", name, edition)
+}
+
+// Import copies the mmdb of edition from the file at path into w. The file
+// may be a tar.gz as MaxMind ships them, a gzipped mmdb or a bare mmdb.
+func Import(path string, edition string, w io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = bufio.NewReader(file)
+	if magic, _ := r.(*bufio.Reader).Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = bufio.NewReader(gz)
+	}
+
+	magic, _ := r.(*bufio.Reader).Peek(tarMagicOffset + 5)
+	if bytes.HasSuffix(magic, []byte("ustar")) {
+		return untar(r, edition, w)
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
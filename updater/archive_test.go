@@ -0,0 +1,80 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip-import-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"GeoLite2-City_20180417.tar.gz": tarball(t, "GeoLite2-City", []byte("mmdb")),
+		"GeoLite2-City.mmdb.gz":         gzipped([]byte("mmdb")),
+		"GeoLite2-City.mmdb":            []byte("mmdb"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, data, 0644)
+
+		var buf bytes.Buffer
+		assert.NoError(t, Import(path, "GeoLite2-City", &buf), name)
+		assert.Equal(t, "mmdb", buf.String(), name)
+	}
+}
+
+func TestImportWrongEdition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip-import-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "GeoLite2-ASN.tar.gz")
+	ioutil.WriteFile(path, tarball(t, "GeoLite2-ASN", []byte("mmdb")), 0644)
+
+	assert.Error(t, Import(path, "GeoLite2-City", ioutil.Discard))
+}
+
+func TestImportCorrupt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "geoip-import-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive := gzipped([]byte("mmdb"))
+	archive = archive[:len(archive)-4]
+	path := filepath.Join(dir, "GeoLite2-City.mmdb.gz")
+	ioutil.WriteFile(path, archive, 0644)
+
+	assert.Error(t, Import(path, "GeoLite2-City", ioutil.Discard))
+	assert.Error(t, Import(filepath.Join(dir, "missing"), "GeoLite2-City", ioutil.Discard))
+}
@@ -0,0 +1,36 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import "strings"
+
+// Attribution returns the notice the licence of databases of databaseType
+// (as in their metadata, e.g. GeoLite2-City) requires to be shown, empty if
+// there is none.
+func Attribution(databaseType string) string {
+	switch kind := strings.ToLower(databaseType); {
+	case strings.HasPrefix(kind, "geolite2"):
+		return "This product includes GeoLite2 data created by MaxMind, available from https://www.maxmind.com."
+	case strings.HasPrefix(kind, "dbip"):
+		return "IP Geolocation by DB-IP (https://db-ip.com), licensed under CC BY 4.0."
+	}
+	return ""
+}
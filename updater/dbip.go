@@ -0,0 +1,108 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultDBIPURL is where DB-IP serves its free Lite databases.
+const DefaultDBIPURL = "https://download.db-ip.com/free"
+
+// DBIP fetches DB-IP's freely redistributable Lite editions (dbip-city-lite,
+// dbip-country-lite and dbip-asn-lite). They need no account and are
+// published monthly as gzipped mmdb under CC BY 4.0, which requires
+// attribution. DB-IP publishes no checksum files, so builds are only
+// verified by the gzip checksum.
+type DBIP struct {
+	BaseURL string
+	// Client and Retry as for MaxMind.
+	Client *http.Client
+	Retry  Retry
+}
+
+// Tells the current month, replaced in tests.
+var now = time.Now
+
+// URL returns the download URL of the build of edition for month.
+func (d *DBIP) URL(edition string, month time.Time) string {
+	base := d.BaseURL
+	if len(base) <= 0 {
+		base = DefaultDBIPURL
+	}
+	return fmt.Sprintf("%s/%s-%s.mmdb.gz",
+		strings.TrimSuffix(base, "/"), url.PathEscape(edition), month.Format("2006-01"))
+}
+
+// IsDBIPEdition returns whether DB-IP provides edition, that is whether it is
+// one of the dbip-*-lite ones.
+func IsDBIPEdition(edition string) bool {
+	return strings.HasPrefix(edition, "dbip-") && strings.HasSuffix(edition, "-lite")
+}
+
+// Download fetches the latest build of edition and writes the mmdb into w.
+// A new month's build only appears some time into the month, until then the
+// previous month's is the latest.
+func (d *DBIP) Download(edition string, since Release, w io.Writer) (Release, error) {
+	if !IsDBIPEdition(edition) {
+		return Release{}, fmt.Errorf("DB-IP only provides dbip-*-lite editions, not %s", edition)
+	}
+
+	year, month, _ := now().UTC().Date()
+	current := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	archive, err := d.stage(edition, current, since)
+	if status, ok := err.(statusError); ok && status.StatusCode == http.StatusNotFound {
+		archive, err = d.stage(edition, current.AddDate(0, -1, 0), since)
+	}
+	if err != nil {
+		return Release{}, err
+	}
+	defer archive.Close()
+
+	// The gzip checksum is only known at the end, until then w gets
+	// unverified data.
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return Release{}, fmt.Errorf("corrupt %s archive: %s", edition, err)
+	}
+	defer gz.Close()
+	if _, err := io.Copy(w, gz); err != nil {
+		return Release{}, fmt.Errorf("corrupt %s archive: %s", edition, err)
+	}
+	return archive.release, nil
+}
+
+// Fetches the build of month.
+func (d *DBIP) stage(edition string, month time.Time, since Release) (*staged, error) {
+	return stage(d.Retry, edition, since, func(header http.Header) (*http.Response, error) {
+		req, err := newRequest(d.URL(edition, month), header)
+		if err != nil {
+			return nil, err
+		}
+		return send(d.Client, req)
+	})
+}
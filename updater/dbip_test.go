@@ -0,0 +1,141 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apachelogger/geoip-kde-org/database/dbtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func dbipMMDB(t *testing.T) []byte {
+	var buf bytes.Buffer
+	if err := dbtest.Write(&buf, dbtest.Options{DatabaseType: "DBIP-City-Lite"}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+// Stub of download.db-ip.com serving files by path.
+func stubDBIP(files map[string][]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		etag := `"` + sha256sum(data) + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	}))
+}
+
+func stubNow(t *testing.T, date string) {
+	month, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = func() time.Time { return month }
+}
+
+func TestDBIPURL(t *testing.T) {
+	d := &DBIP{}
+	month := time.Date(2018, time.April, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "https://download.db-ip.com/free/dbip-city-lite-2018-04.mmdb.gz",
+		d.URL("dbip-city-lite", month))
+}
+
+func TestDBIPDownload(t *testing.T) {
+	mmdb := dbipMMDB(t)
+	server := stubDBIP(map[string][]byte{"/dbip-city-lite-2018-04.mmdb.gz": gzipped(mmdb)})
+	defer server.Close()
+	stubNow(t, "2018-04-17")
+	defer func() { now = time.Now }()
+
+	d := &DBIP{BaseURL: server.URL}
+	var buf bytes.Buffer
+	release, err := d.Download("dbip-city-lite", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, mmdb, buf.Bytes())
+	assert.NotEmpty(t, release.ETag)
+
+	buf.Reset()
+	_, err = d.Download("dbip-city-lite", release, &buf)
+	assert.Equal(t, ErrNotModified, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestDBIPDownloadPreviousMonth(t *testing.T) {
+	mmdb := dbipMMDB(t)
+	server := stubDBIP(map[string][]byte{"/dbip-city-lite-2018-03.mmdb.gz": gzipped(mmdb)})
+	defer server.Close()
+	// The 31st, going back a month must not land in March again.
+	stubNow(t, "2018-05-31")
+	defer func() { now = time.Now }()
+
+	d := &DBIP{BaseURL: server.URL}
+	_, err := d.Download("dbip-city-lite", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+
+	stubNow(t, "2018-04-01")
+	var buf bytes.Buffer
+	_, err = d.Download("dbip-city-lite", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, mmdb, buf.Bytes())
+}
+
+func TestDBIPDownloadCorrupt(t *testing.T) {
+	archive := gzipped(dbipMMDB(t))
+	// Flip a bit of the gzip checksum.
+	archive[len(archive)-6] ^= 1
+	server := stubDBIP(map[string][]byte{"/dbip-city-lite-2018-04.mmdb.gz": archive})
+	defer server.Close()
+	stubNow(t, "2018-04-17")
+	defer func() { now = time.Now }()
+
+	d := &DBIP{BaseURL: server.URL}
+	_, err := d.Download("dbip-city-lite", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestDBIPDownloadUnknownEdition(t *testing.T) {
+	d := &DBIP{}
+	_, err := d.Download("GeoLite2-City", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,120 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// GeoIPConf is the part of geoipupdate's GeoIP.conf that is relevant to us.
+// See https://dev.maxmind.com/geoip/updating-databases for the format.
+type GeoIPConf struct {
+	AccountID         string
+	LicenseKey        string
+	EditionIDs        []string
+	DatabaseDirectory string
+	Host              string
+}
+
+// Settings geoipupdate knows about but that do not apply to us.
+var ignoredGeoIPConfKeys = map[string]bool{
+	"Proxy":                    true,
+	"ProxyUserPassword":        true,
+	"PreserveFileTimes":        true,
+	"LockFile":                 true,
+	"RetryFor":                 true,
+	"Parallelism":              true,
+	"Protocol":                 true,
+	"SkipHostnameVerification": true,
+	"SkipPeerVerification":     true,
+}
+
+// ParseGeoIPConf reads a GeoIP.conf. Like geoipupdate it rejects unknown
+// settings so typos don't go unnoticed.
+func ParseGeoIPConf(r io.Reader) (*GeoIPConf, error) {
+	conf := &GeoIPConf{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) <= 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		key, values := fields[0], fields[1:]
+		if ignoredGeoIPConfKeys[key] {
+			continue
+		}
+		if len(values) <= 0 {
+			return nil, fmt.Errorf("line %d: %s has no value", line, key)
+		}
+
+		switch key {
+		case "AccountID", "UserId":
+			conf.AccountID = values[0]
+		case "LicenseKey":
+			conf.LicenseKey = values[0]
+		case "EditionIDs", "ProductIds":
+			conf.EditionIDs = values
+		case "DatabaseDirectory":
+			conf.DatabaseDirectory = values[0]
+		case "Host":
+			conf.Host = values[0]
+		default:
+			return nil, fmt.Errorf("line %d: unknown setting %s", line, key)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// LoadGeoIPConf reads a GeoIP.conf file (see ParseGeoIPConf).
+func LoadGeoIPConf(path string) (*GeoIPConf, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseGeoIPConf(file)
+}
+
+// Apply copies the credentials and host into m. Unset settings leave m
+// alone.
+func (c *GeoIPConf) Apply(m *MaxMind) {
+	if len(c.AccountID) > 0 {
+		m.AccountID = c.AccountID
+	}
+	if len(c.LicenseKey) > 0 {
+		m.LicenseKey = c.LicenseKey
+	}
+	if len(c.Host) > 0 {
+		// geoipupdate takes bare host names as well as URLs.
+		m.BaseURL = c.Host
+		if !strings.Contains(c.Host, "://") {
+			m.BaseURL = "https://" + c.Host
+		}
+	}
+}
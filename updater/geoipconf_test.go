@@ -0,0 +1,76 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseGeoIPConf(t *testing.T) {
+	conf, err := ParseGeoIPConf(strings.NewReader(`
+# GeoIP.conf file for geoipupdate
+AccountID 42
+LicenseKey secret
+
+EditionIDs GeoLite2-Country GeoLite2-City
+DatabaseDirectory /var/lib/GeoIP
+LockFile /var/lib/GeoIP/.geoipupdate.lock
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, &GeoIPConf{
+		AccountID:         "42",
+		LicenseKey:        "secret",
+		EditionIDs:        []string{"GeoLite2-Country", "GeoLite2-City"},
+		DatabaseDirectory: "/var/lib/GeoIP",
+	}, conf)
+}
+
+func TestParseGeoIPConfLegacy(t *testing.T) {
+	conf, err := ParseGeoIPConf(strings.NewReader(`
+UserId 42
+LicenseKey secret
+ProductIds GeoLite2-City
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, "42", conf.AccountID)
+	assert.Equal(t, []string{"GeoLite2-City"}, conf.EditionIDs)
+}
+
+func TestParseGeoIPConfInvalid(t *testing.T) {
+	_, err := ParseGeoIPConf(strings.NewReader("LicenceKey secret\n"))
+	assert.Error(t, err)
+	_, err = ParseGeoIPConf(strings.NewReader("AccountID\n"))
+	assert.Error(t, err)
+}
+
+func TestGeoIPConfApply(t *testing.T) {
+	m := &MaxMind{BaseURL: DefaultBaseURL, AccountID: "1", LicenseKey: "key"}
+	(&GeoIPConf{AccountID: "42"}).Apply(m)
+	assert.Equal(t, &MaxMind{BaseURL: DefaultBaseURL, AccountID: "42", LicenseKey: "key"}, m)
+
+	(&GeoIPConf{Host: "updates.maxmind.com"}).Apply(m)
+	assert.Equal(t, "https://updates.maxmind.com", m.BaseURL)
+	(&GeoIPConf{Host: "http://localhost:1234"}).Apply(m)
+	assert.Equal(t, "http://localhost:1234", m.BaseURL)
+}
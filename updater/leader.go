@@ -0,0 +1,75 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Leader fetches databases from another instance of this service which
+// serves its databases to followers on its admin listener. Only the leader
+// needs upstream credentials then.
+type Leader struct {
+	// URL of the leader's admin listener.
+	URL string
+	// Token the leader expects from its followers.
+	Token string
+	// Client and Retry as for MaxMind.
+	Client *http.Client
+	Retry  Retry
+}
+
+// ReplicaURL returns where the leader serves edition.
+func (l *Leader) ReplicaURL(edition string) string {
+	return strings.TrimSuffix(l.URL, "/") + "/replica/" + url.PathEscape(edition)
+}
+
+// Download fetches the database of edition the leader currently serves and
+// verifies it against its checksum, which doubles as its ETag. Nothing is
+// written to w unless the database is intact. If the leader serves the
+// same build as since ErrNotModified is returned.
+func (l *Leader) Download(edition string, since Release, w io.Writer) (Release, error) {
+	if len(l.URL) <= 0 {
+		return Release{}, fmt.Errorf("no leader to follow")
+	}
+
+	replica, err := stage(l.Retry, edition, since, func(header http.Header) (*http.Response, error) {
+		req, err := newRequest(l.ReplicaURL(edition), header)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+l.Token)
+		return send(l.Client, req)
+	})
+	if err != nil {
+		return Release{}, err
+	}
+	defer replica.Close()
+	if err := replica.verify(edition, strings.Trim(replica.release.ETag, `"`)); err != nil {
+		return Release{}, err
+	}
+	_, err = io.Copy(w, replica)
+	return replica.release, err
+}
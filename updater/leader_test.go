@@ -0,0 +1,82 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Stub of a leader's admin listener serving data as GeoLite2-City.
+func stubLeader(data []byte, checksum string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/replica/GeoLite2-City", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("ETag", `"`+checksum+`"`)
+		http.ServeContent(w, r, "GeoLite2-City.mmdb", time.Time{}, bytes.NewReader(data))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestLeaderDownload(t *testing.T) {
+	server := stubLeader([]byte("mmdb"), sha256sum([]byte("mmdb")))
+	defer server.Close()
+
+	l := &Leader{URL: server.URL + "/", Token: "secret"}
+	var buf bytes.Buffer
+	release, err := l.Download("GeoLite2-City", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mmdb", buf.String())
+	assert.Equal(t, `"`+sha256sum([]byte("mmdb"))+`"`, release.ETag)
+
+	buf.Reset()
+	_, err = l.Download("GeoLite2-City", release, &buf)
+	assert.Equal(t, ErrNotModified, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestLeaderDownloadChecksumMismatch(t *testing.T) {
+	server := stubLeader([]byte("mmdb"), sha256sum([]byte("something else")))
+	defer server.Close()
+
+	l := &Leader{URL: server.URL, Token: "secret"}
+	var buf bytes.Buffer
+	_, err := l.Download("GeoLite2-City", Release{}, &buf)
+	assert.Error(t, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestLeaderDownloadUnauthorized(t *testing.T) {
+	server := stubLeader([]byte("mmdb"), sha256sum([]byte("mmdb")))
+	defer server.Close()
+
+	l := &Leader{URL: server.URL, Token: "wrong", Retry: fastRetry}
+	_, err := l.Download("GeoLite2-City", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,135 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// DefaultBaseURL is where MaxMind serves its database permalinks.
+const DefaultBaseURL = "https://download.maxmind.com"
+
+// MaxMind fetches database editions through MaxMind's permalinks. These
+// require an account ID and licence key which are sent as basic auth.
+type MaxMind struct {
+	BaseURL    string
+	AccountID  string
+	LicenseKey string
+	// Client defaults to one with reasonable timeouts.
+	Client *http.Client
+	Retry  Retry
+}
+
+// URL returns the permalink of an edition's file with the given suffix
+// (e.g. tar.gz or tar.gz.sha256).
+func (m *MaxMind) URL(edition string, suffix string) string {
+	base := m.BaseURL
+	if len(base) <= 0 {
+		base = DefaultBaseURL
+	}
+	return fmt.Sprintf("%s/geoip/databases/%s/download?suffix=%s",
+		strings.TrimSuffix(base, "/"), url.PathEscape(edition), url.QueryEscape(suffix))
+}
+
+// Issues a single request. Failures worth retrying are temporaryErrors.
+func (m *MaxMind) get(edition string, suffix string, header http.Header) (*http.Response, error) {
+	req, err := newRequest(m.URL(edition, suffix), header)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(m.AccountID, m.LicenseKey)
+	return send(m.Client, req)
+}
+
+// Checksum fetches the published SHA256 of the latest archive of edition.
+// The companion file is in sha256sum format, i.e. "<hex>  <filename>".
+func (m *MaxMind) Checksum(edition string) (string, error) {
+	var data []byte
+	err := m.Retry.do(func() error {
+		resp, err := m.get(edition, "tar.gz.sha256", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			return temporaryError{err}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 1 {
+		return "", fmt.Errorf("empty checksum for %s", edition)
+	}
+	sum := strings.ToLower(fields[0])
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed checksum for %s: %q", edition, fields[0])
+	}
+	return sum, nil
+}
+
+// Download fetches the latest archive of edition, verifies it against the
+// published checksum and extracts its mmdb into w. Nothing is written to
+// w unless the archive is intact. If upstream has nothing newer than since
+// ErrNotModified is returned, otherwise the Release of the new archive.
+func (m *MaxMind) Download(edition string, since Release, w io.Writer) (Release, error) {
+	// Reading from Body.Resp via Gzip and Bufio is substantially slower
+	// than first downloading the entire body and reading from local. I am
+	// not entirely sure why that is since bufio should make it fast :(
+	archive, err := stage(m.Retry, edition, since, func(header http.Header) (*http.Response, error) {
+		return m.get(edition, "tar.gz", header)
+	})
+	if err != nil {
+		return Release{}, err
+	}
+	defer archive.Close()
+	// Fetched after the archive as we only know whether we need it then.
+	expected, err := m.Checksum(edition)
+	if err != nil {
+		return Release{}, err
+	}
+	if err := archive.verify(edition, expected); err != nil {
+		return Release{}, err
+	}
+
+	gzip, err := gzip.NewReader(bufio.NewReader(archive))
+	if err != nil {
+		return Release{}, err
+	}
+	defer gzip.Close()
+	if err := untar(gzip, edition, w); err != nil {
+		return Release{}, err
+	}
+	return archive.release, nil
+}
@@ -0,0 +1,176 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Builds a tar.gz the way MaxMind lays them out: a dated directory with the
+// mmdb and some licence noise next to it.
+func tarball(t *testing.T, edition string, mmdb []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	files := map[string][]byte{
+		edition + "_20180417/LICENSE.txt":          []byte("licence"),
+		edition + "_20180417/" + edition + ".mmdb": mmdb,
+	}
+	for name, data := range files {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+func sha256sum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Stub of download.maxmind.com. Only accepts the account 42:secret.
+func stubMaxMind(t *testing.T, edition string, archive []byte) *httptest.Server {
+	return stubMaxMindWithChecksum(t, edition, archive, sha256sum(archive))
+}
+
+func stubMaxMindWithChecksum(t *testing.T, edition string, archive []byte, checksum string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/geoip/databases/"+edition+"/download", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "42" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Query().Get("suffix") {
+		case "tar.gz":
+			etag := `"` + checksum + `"`
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Last-Modified", "Tue, 17 Apr 2018 00:00:00 GMT")
+			w.Write(archive)
+		case "tar.gz.sha256":
+			w.Write([]byte(checksum + "  " + edition + "_20180417.tar.gz\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestMaxMindURL(t *testing.T) {
+	m := &MaxMind{}
+	assert.Equal(t, "https://download.maxmind.com/geoip/databases/GeoLite2-City/download?suffix=tar.gz",
+		m.URL("GeoLite2-City", "tar.gz"))
+	m.BaseURL = "http://localhost:1234/"
+	assert.Equal(t, "http://localhost:1234/geoip/databases/GeoLite2-ASN/download?suffix=tar.gz.sha256",
+		m.URL("GeoLite2-ASN", "tar.gz.sha256"))
+}
+
+func TestMaxMindDownload(t *testing.T) {
+	server := stubMaxMind(t, "GeoLite2-City", tarball(t, "GeoLite2-City", []byte("mmdb")))
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, AccountID: "42", LicenseKey: "secret"}
+	var buf bytes.Buffer
+	release, err := m.Download("GeoLite2-City", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mmdb", buf.String())
+	assert.NotEmpty(t, release.ETag)
+	assert.Equal(t, "Tue, 17 Apr 2018 00:00:00 GMT", release.LastModified)
+
+	buf.Reset()
+	_, err = m.Download("GeoLite2-City", release, &buf)
+	assert.Equal(t, ErrNotModified, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestMaxMindDownloadUnauthorized(t *testing.T) {
+	server := stubMaxMind(t, "GeoLite2-City", tarball(t, "GeoLite2-City", []byte("mmdb")))
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, AccountID: "42", LicenseKey: "wrong"}
+	var buf bytes.Buffer
+	_, err := m.Download("GeoLite2-City", Release{}, &buf)
+	assert.Error(t, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestMaxMindDownloadMissingEntry(t *testing.T) {
+	server := stubMaxMind(t, "GeoLite2-City", tarball(t, "GeoLite2-Country", []byte("mmdb")))
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, AccountID: "42", LicenseKey: "secret"}
+	_, err := m.Download("GeoLite2-City", Release{}, ioutil.Discard)
+	assert.Error(t, err)
+}
+
+func TestMaxMindChecksum(t *testing.T) {
+	archive := tarball(t, "GeoLite2-City", []byte("mmdb"))
+	server := stubMaxMind(t, "GeoLite2-City", archive)
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, AccountID: "42", LicenseKey: "secret"}
+	sum, err := m.Checksum("GeoLite2-City")
+	assert.NoError(t, err)
+	assert.Equal(t, sha256sum(archive), sum)
+}
+
+func TestMaxMindDownloadChecksumMismatch(t *testing.T) {
+	archive := tarball(t, "GeoLite2-City", []byte("mmdb"))
+	server := stubMaxMindWithChecksum(t, "GeoLite2-City", archive, sha256sum([]byte("something else")))
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, AccountID: "42", LicenseKey: "secret"}
+	var buf bytes.Buffer
+	_, err := m.Download("GeoLite2-City", Release{}, &buf)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestMaxMindChecksumMalformed(t *testing.T) {
+	server := stubMaxMindWithChecksum(t, "GeoLite2-City", nil, "<html>nope</html>")
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, AccountID: "42", LicenseKey: "secret"}
+	_, err := m.Checksum("GeoLite2-City")
+	assert.Error(t, err)
+}
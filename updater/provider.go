@@ -0,0 +1,215 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Provider fetches the latest build of a database edition, makes sure it
+// arrived intact as far as upstream allows and writes the mmdb to w. On
+// failure w may have been written to already, whatever it got has to be
+// discarded then (storage.Store.Install does), as does checking that it is
+// a database at all. If upstream has nothing newer than since
+// ErrNotModified is returned, otherwise the Release of the new build.
+type Provider interface {
+	Download(edition string, since Release, w io.Writer) (Release, error)
+}
+
+// ErrNotModified is returned by downloads when upstream has nothing newer
+// than the Release we already have.
+var ErrNotModified = errors.New("not modified")
+
+// Release identifies an upstream build by its HTTP validators. Store it with
+// the database and pass it to the next download to only get newer builds.
+type Release struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// Sets the conditions under which upstream should send us the file.
+func (r Release) conditions(header http.Header) {
+	if len(r.ETag) > 0 {
+		header.Set("If-None-Match", r.ETag)
+	}
+	if len(r.LastModified) > 0 {
+		header.Set("If-Modified-Since", r.LastModified)
+	}
+}
+
+// Validator for If-Range, empty if the release can't be identified.
+func (r Release) validator() string {
+	if len(r.ETag) > 0 {
+		return r.ETag
+	}
+	return r.LastModified
+}
+
+// statusError is an unexpected HTTP status.
+type statusError struct {
+	StatusCode int
+	error
+}
+
+// Creates a GET request for url with header.
+func newRequest(url string, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	return req, nil
+}
+
+// Sends a single request. Failures worth retrying are temporaryErrors.
+func send(client *http.Client, req *http.Request) (*http.Response, error) {
+	if client == nil {
+		client = defaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, temporaryError{err}
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent:
+		return resp, nil
+	case resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		return nil, ErrNotModified
+	}
+	resp.Body.Close()
+	err = statusError{resp.StatusCode, fmt.Errorf("GET %s: unexpected status %s", req.URL.Path, resp.Status)}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return nil, temporaryError{err}
+	}
+	return nil, err
+}
+
+// Fetches a file through get into file. Interrupted transfers are resumed
+// with a Range request, provided upstream can tell us whether the file is
+// still the same.
+func fetch(retry Retry, get func(http.Header) (*http.Response, error), since Release, file *os.File, hash hash.Hash) (Release, error) {
+	var release Release
+	var written int64
+	err := retry.do(func() error {
+		header := http.Header{}
+		if written > 0 && len(release.validator()) > 0 {
+			header.Set("Range", fmt.Sprintf("bytes=%d-", written))
+			header.Set("If-Range", release.validator())
+		} else {
+			since.conditions(header)
+		}
+
+		resp, err := get(header)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusPartialContent {
+			// Whole file, possibly a new one. Start over.
+			if _, err := file.Seek(0, 0); err != nil {
+				return err
+			}
+			if err := file.Truncate(0); err != nil {
+				return err
+			}
+			hash.Reset()
+			written = 0
+			release = Release{
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+			}
+		}
+
+		n, err := io.Copy(io.MultiWriter(file, hash), resp.Body)
+		written += n
+		if err != nil {
+			return temporaryError{err}
+		}
+		return nil
+	})
+	return release, err
+}
+
+// StagingDir is where downloads are staged, nothing else should live there.
+// Unset they go to the system's temporary directory.
+var StagingDir = ""
+
+// A download staged in a temporary file, rewound and ready to be read.
+type staged struct {
+	*os.File
+	release Release
+	sum     string
+}
+
+// Fetches through get into a temporary file. Downloads are staged rather
+// than streamed so they can be resumed and verified before anything gets
+// unpacked. Close removes the file again.
+func stage(retry Retry, edition string, since Release, get func(http.Header) (*http.Response, error)) (*staged, error) {
+	if len(StagingDir) > 0 {
+		if err := os.MkdirAll(StagingDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := ioutil.TempFile(StagingDir, "geoip-"+strings.ToLower(edition))
+	if err != nil {
+		return nil, err
+	}
+	s := &staged{File: file}
+	hash := sha256.New()
+	s.release, err = fetch(retry, get, since, file, hash)
+	if err == nil {
+		_, err = file.Seek(0, 0)
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.sum = hex.EncodeToString(hash.Sum(nil))
+	return s, nil
+}
+
+// Fails unless the download has the expected SHA256.
+func (s *staged) verify(edition string, expected string) error {
+	if s.sum != expected {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", edition, expected, s.sum)
+	}
+	return nil
+}
+
+func (s *staged) Close() error {
+	err := s.File.Close()
+	os.Remove(s.Name())
+	return err
+}
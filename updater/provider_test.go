@@ -0,0 +1,63 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStageInStagingDir(t *testing.T) {
+	old := StagingDir
+	StagingDir = filepath.Join(t.TempDir(), "staging")
+	defer func() { StagingDir = old }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("mmdb"))
+	}))
+	defer server.Close()
+	get := func(header http.Header) (*http.Response, error) {
+		req, err := newRequest(server.URL, header)
+		if err != nil {
+			return nil, err
+		}
+		return send(nil, req)
+	}
+
+	s, err := stage(Retry{Attempts: 1}, "GeoLite2-City", Release{}, get)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, StagingDir, filepath.Dir(s.Name()))
+	data, err := ioutil.ReadAll(s)
+	assert.NoError(t, err)
+	assert.Equal(t, "mmdb", string(data))
+
+	s.Close()
+	files, err := ioutil.ReadDir(StagingDir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
@@ -0,0 +1,111 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Retry configures how transient failures (network trouble, 5xx and 429
+// responses) are retried. Zero values fall back to sensible defaults.
+type Retry struct {
+	// Attempts in total, including the first one.
+	Attempts int
+	// Backoff before the first retry. Doubles on every further retry up to
+	// MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetry spreads 5 attempts over roughly a minute.
+var DefaultRetry = Retry{Attempts: 5, Backoff: 4 * time.Second, MaxBackoff: time.Minute}
+
+// Used when no http.Client is set. The overall timeout is generous as the
+// City archive is quite big; the others make sure a dead peer is noticed
+// quickly.
+var defaultClient = &http.Client{
+	Timeout: 10 * time.Minute,
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
+
+// temporaryError marks failures worth another attempt.
+type temporaryError struct {
+	error
+}
+
+func (r Retry) withDefaults() Retry {
+	if r.Attempts <= 0 {
+		r.Attempts = DefaultRetry.Attempts
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = DefaultRetry.Backoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = DefaultRetry.MaxBackoff
+	}
+	return r
+}
+
+// Exponential backoff with jitter so a fleet restarted at once doesn't
+// hammer upstream in lockstep. The result is in [backoff/2, backoff).
+func (r Retry) delay(retry int) time.Duration {
+	backoff := r.Backoff
+	for i := 1; i < retry && backoff < r.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Runs fn until it succeeds, fails permanently or we run out of attempts.
+func (r Retry) do(fn func() error) error {
+	r = r.withDefaults()
+	var err error
+	for attempt := 0; attempt < r.Attempts; attempt++ {
+		if attempt > 0 {
+			delay := r.delay(attempt)
+			log.Printf("Retrying in %s: %s", delay, err)
+			time.Sleep(delay)
+		}
+		err = fn()
+		if temp, ok := err.(temporaryError); ok {
+			err = temp.error
+			continue
+		}
+		return err
+	}
+	return err
+}
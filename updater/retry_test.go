@@ -0,0 +1,139 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var fastRetry = Retry{Attempts: 3, Backoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+
+func TestRetryDelay(t *testing.T) {
+	r := Retry{Backoff: 4 * time.Second, MaxBackoff: 10 * time.Second}
+	for i := 0; i < 100; i++ {
+		d := r.delay(1)
+		assert.True(t, d >= 2*time.Second && d <= 4*time.Second, d)
+		d = r.delay(2)
+		assert.True(t, d >= 4*time.Second && d <= 8*time.Second, d)
+		d = r.delay(10)
+		assert.True(t, d >= 5*time.Second && d <= 10*time.Second, d)
+	}
+}
+
+// Stub where the archive is served by handler, checksums always work.
+func stubArchive(t *testing.T, archive []byte, handler func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("suffix") == "tar.gz.sha256" {
+			fmt.Fprintf(w, "%s  GeoLite2-City_20180417.tar.gz\n", sha256sum(archive))
+			return
+		}
+		handler(w, r)
+	}))
+}
+
+func TestMaxMindDownloadRetries(t *testing.T) {
+	archive := tarball(t, "GeoLite2-City", []byte("mmdb"))
+	requests := 0
+	server := stubArchive(t, archive, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(archive)
+	})
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, Retry: fastRetry}
+	var buf bytes.Buffer
+	_, err := m.Download("GeoLite2-City", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mmdb", buf.String())
+	assert.Equal(t, 3, requests)
+}
+
+func TestMaxMindDownloadGivesUp(t *testing.T) {
+	requests := 0
+	server := stubArchive(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, Retry: fastRetry}
+	_, err := m.Download("GeoLite2-City", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "502")
+	assert.Equal(t, 3, requests)
+}
+
+func TestMaxMindDownloadNoRetryOnClientError(t *testing.T) {
+	requests := 0
+	server := stubArchive(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, Retry: fastRetry}
+	_, err := m.Download("GeoLite2-City", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+	assert.Equal(t, 1, requests)
+}
+
+func TestMaxMindDownloadResume(t *testing.T) {
+	archive := tarball(t, "GeoLite2-City", bytes.Repeat([]byte("mmdb"), 1024))
+	half := len(archive) / 2
+	var ranges []string
+	server := stubArchive(t, archive, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if rng := r.Header.Get("Range"); len(rng) > 0 {
+			ranges = append(ranges, rng)
+			assert.Equal(t, `"v1"`, r.Header.Get("If-Range"))
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", half, len(archive)-1, len(archive)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(archive[half:])
+			return
+		}
+		// Promise everything, deliver half, hang up.
+		w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+		w.Write(archive[:half])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	})
+	defer server.Close()
+
+	m := &MaxMind{BaseURL: server.URL, Retry: fastRetry}
+	var buf bytes.Buffer
+	release, err := m.Download("GeoLite2-City", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("mmdb"), 1024), buf.Bytes())
+	assert.Equal(t, `"v1"`, release.ETag)
+	assert.Equal(t, []string{fmt.Sprintf("bytes=%d-", half)}, ranges)
+}
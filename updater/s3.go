@@ -0,0 +1,206 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3 fetches databases from an S3 compatible bucket (AWS, MinIO, ...) that a
+// single host populates through Publish, so only that host needs to talk to
+// the upstream provider. Every edition is stored as <Prefix><edition>.mmdb
+// with the SHA256 of its content in the object metadata. Buckets populated
+// by other means may instead have a <Prefix><edition>.mmdb.sha256 next to
+// it in sha256sum format.
+type S3 struct {
+	// Endpoint is the host[:port] of the service (e.g. s3.amazonaws.com).
+	Endpoint string
+	// Insecure talks plain HTTP rather than HTTPS.
+	Insecure bool
+	// Region defaults to us-east-1, which is what MinIO uses by default.
+	Region    string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Prefix    string
+	// Transport defaults to the one of minio-go.
+	Transport http.RoundTripper
+	// Timeout bounds every Download and Publish (default 10 minutes, like
+	// the HTTP providers).
+	Timeout time.Duration
+}
+
+// The checksum metadata key, sent as X-Amz-Meta-Sha256.
+const s3ChecksumKey = "Sha256"
+
+// Key returns the object key of edition.
+func (s *S3) Key(edition string) string {
+	return s.Prefix + edition + ".mmdb"
+}
+
+// A context bounded by Timeout so a hung service can't stall updates.
+func (s *S3) context() (context.Context, context.CancelFunc) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultClient.Timeout
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+func (s *S3) client() (*minio.Client, error) {
+	if len(s.Endpoint) <= 0 || len(s.Bucket) <= 0 {
+		return nil, fmt.Errorf("S3 needs an endpoint and a bucket")
+	}
+	region := s.Region
+	if len(region) <= 0 {
+		region = "us-east-1"
+	}
+	return minio.New(s.Endpoint, &minio.Options{
+		Creds:     credentials.NewStaticV4(s.AccessKey, s.SecretKey, ""),
+		Secure:    !s.Insecure,
+		Region:    region,
+		Transport: s.Transport,
+	})
+}
+
+// Download fetches the current build of edition from the bucket into w and
+// verifies it against its checksum. If the object hasn't changed since
+// ErrNotModified is returned, otherwise the Release of the new object.
+// minio-go retries transient failures on its own.
+func (s *S3) Download(edition string, since Release, w io.Writer) (Release, error) {
+	client, err := s.client()
+	if err != nil {
+		return Release{}, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	key := s.Key(edition)
+
+	info, err := client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to stat s3://%s/%s: %s", s.Bucket, key, err)
+	}
+	if len(since.ETag) > 0 && since.ETag == info.ETag {
+		return Release{}, ErrNotModified
+	}
+	expected, err := s.checksum(ctx, client, key, info)
+	if err != nil {
+		return Release{}, err
+	}
+
+	// Make sure the object doesn't get replaced under our feet, the
+	// checksum wouldn't match.
+	opts := minio.GetObjectOptions{}
+	opts.SetMatchETag(info.ETag)
+	object, err := client.GetObject(ctx, s.Bucket, key, opts)
+	if err != nil {
+		return Release{}, err
+	}
+	defer object.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), object); err != nil {
+		return Release{}, fmt.Errorf("failed to get s3://%s/%s: %s", s.Bucket, key, err)
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return Release{}, fmt.Errorf("checksum mismatch for %s: expected %s, got %s", edition, expected, actual)
+	}
+
+	return Release{
+		ETag:         info.ETag,
+		LastModified: info.LastModified.UTC().Format(http.TimeFormat),
+	}, nil
+}
+
+// Gets the checksum of key from its metadata or the companion object.
+func (s *S3) checksum(ctx context.Context, client *minio.Client, key string, info minio.ObjectInfo) (string, error) {
+	sum, ok := info.UserMetadata[s3ChecksumKey]
+	if !ok {
+		object, err := client.GetObject(ctx, s.Bucket, key+".sha256", minio.GetObjectOptions{})
+		if err != nil {
+			return "", err
+		}
+		defer object.Close()
+		data, err := ioutil.ReadAll(io.LimitReader(object, 1024))
+		if err != nil {
+			return "", fmt.Errorf("no checksum for s3://%s/%s: %s", s.Bucket, key, err)
+		}
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			sum = fields[0]
+		}
+	}
+	sum = strings.ToLower(sum)
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed checksum for s3://%s/%s: %q", s.Bucket, key, sum)
+	}
+	return sum, nil
+}
+
+// Publish uploads the database at path as the current build of edition,
+// unless the bucket has that very build already. Returns whether it
+// uploaded.
+func (s *S3) Publish(edition string, path string) (bool, error) {
+	client, err := s.client()
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	key := s.Key(edition)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return false, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	info, err := client.StatObject(ctx, s.Bucket, key, minio.StatObjectOptions{})
+	if err == nil && info.UserMetadata[s3ChecksumKey] == sum {
+		return false, nil
+	}
+
+	file.Seek(0, 0)
+	_, err = client.PutObject(ctx, s.Bucket, key, file, size, minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		UserMetadata: map[string]string{s3ChecksumKey: sum},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to put s3://%s/%s: %s", s.Bucket, key, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,215 @@
+/*
+	Copyright © 2018 Harald Sitter <sitter@kde.org>
+
+	This program is free software; you can redistribute it and/or
+	modify it under the terms of the GNU General Public License as
+	published by the Free Software Foundation; either version 3 of
+	the License or any later version accepted by the membership of
+	KDE e.V. (or its successor approved by the membership of KDE
+	e.V.), which shall act as a proxy defined in Section 14 of
+	version 3 of the license.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU General Public License for more details.
+
+	You should have received a copy of the GNU General Public License
+	along with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package updater
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type s3Object struct {
+	data     []byte
+	checksum string
+}
+
+// Stub of an S3 service with the bucket geoip. Serves over TLS so minio-go
+// doesn't use streaming signatures.
+type stubS3 struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string]s3Object
+	puts    int
+}
+
+func newStubS3() *stubS3 {
+	s := &stubS3{objects: map[string]s3Object{}}
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *stubS3) put(key string, data []byte, checksum string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = s3Object{data, checksum}
+}
+
+func (s *stubS3) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.TrimPrefix(r.URL.Path, "/geoip/")
+	if r.Method == "PUT" {
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = s3Object{data, r.Header.Get("X-Amz-Meta-Sha256")}
+		s.puts++
+		w.Header().Set("ETag", etag(data))
+		return
+	}
+
+	object, ok := s.objects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if match := r.Header.Get("If-Match"); len(match) > 0 && match != etag(object.data) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("ETag", etag(object.data))
+	w.Header().Set("Last-Modified", "Tue, 17 Apr 2018 00:00:00 GMT")
+	w.Header().Set("Content-Length", strconv.Itoa(len(object.data)))
+	if len(object.checksum) > 0 {
+		w.Header().Set("X-Amz-Meta-Sha256", object.checksum)
+	}
+	if r.Method == "GET" {
+		w.Write(object.data)
+	}
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (s *stubS3) client() *S3 {
+	u, _ := url.Parse(s.URL)
+	return &S3{
+		Endpoint:  u.Host,
+		AccessKey: "key",
+		SecretKey: "secret",
+		Bucket:    "geoip",
+		Prefix:    "fleet/",
+		Transport: s.Client().Transport,
+	}
+}
+
+func TestS3Download(t *testing.T) {
+	stub := newStubS3()
+	defer stub.Close()
+	stub.put("fleet/GeoLite2-City.mmdb", []byte("mmdb"), sha256sum([]byte("mmdb")))
+
+	s := stub.client()
+	var buf bytes.Buffer
+	release, err := s.Download("GeoLite2-City", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mmdb", buf.String())
+	assert.NotEmpty(t, release.ETag)
+
+	buf.Reset()
+	_, err = s.Download("GeoLite2-City", release, &buf)
+	assert.Equal(t, ErrNotModified, err)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestS3DownloadChecksumFile(t *testing.T) {
+	stub := newStubS3()
+	defer stub.Close()
+	stub.put("fleet/GeoLite2-City.mmdb", []byte("mmdb"), "")
+	stub.put("fleet/GeoLite2-City.mmdb.sha256", []byte(sha256sum([]byte("mmdb"))+"  GeoLite2-City.mmdb\n"), "")
+
+	var buf bytes.Buffer
+	_, err := stub.client().Download("GeoLite2-City", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mmdb", buf.String())
+}
+
+func TestS3DownloadChecksumMismatch(t *testing.T) {
+	stub := newStubS3()
+	defer stub.Close()
+	stub.put("fleet/GeoLite2-City.mmdb", []byte("mmdb"), sha256sum([]byte("something else")))
+
+	var buf bytes.Buffer
+	_, err := stub.client().Download("GeoLite2-City", Release{}, &buf)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+}
+
+func TestS3DownloadMissing(t *testing.T) {
+	stub := newStubS3()
+	defer stub.Close()
+
+	_, err := stub.client().Download("GeoLite2-City", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestS3Publish(t *testing.T) {
+	stub := newStubS3()
+	defer stub.Close()
+	dir, err := ioutil.TempDir("", "geoip-s3-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "GeoLite2-City.mmdb")
+	ioutil.WriteFile(path, []byte("mmdb"), 0644)
+
+	s := stub.client()
+	published, err := s.Publish("GeoLite2-City", path)
+	assert.NoError(t, err)
+	assert.True(t, published)
+	// Unchanged builds aren't uploaded again.
+	published, err = s.Publish("GeoLite2-City", path)
+	assert.NoError(t, err)
+	assert.False(t, published)
+	assert.Equal(t, 1, stub.puts)
+
+	var buf bytes.Buffer
+	_, err = s.Download("GeoLite2-City", Release{}, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "mmdb", buf.String())
+}
+
+func TestS3Timeout(t *testing.T) {
+	done := make(chan struct{})
+	hung := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer hung.Close()
+	defer close(done)
+
+	u, _ := url.Parse(hung.URL)
+	s := &S3{
+		Endpoint:  u.Host,
+		Bucket:    "geoip",
+		Transport: hung.Client().Transport,
+		Timeout:   100 * time.Millisecond,
+	}
+	_, err := s.Download("GeoLite2-City", Release{}, &bytes.Buffer{})
+	assert.Error(t, err)
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	ioutil.WriteFile(path, []byte("mmdb"), 0644)
+	_, err = s.Publish("GeoLite2-City", path)
+	assert.Error(t, err)
+}